@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// catOptions selects the processing applied to a member before it is written to stdout
+type catOptions struct {
+	decompress     bool
+	decrypt        bool
+	encryptAlgo    string
+	encryptKeyFile string
+	spillDir       string
+}
+
+// catMember writes the content of a single member of the archive to stdout
+func catMember(file *os.File, path string, opts catOptions) {
+	if *file == (os.File{}) {
+		file = os.Stdin
+	}
+
+	cmds, err := catPipeline(path, opts)
+	if err != nil {
+		log.Print(err)
+		os.Exit(exitUsage)
+	}
+
+	if len(cmds) == 0 {
+		if err = xbstream.Cat(os.Stdout, openStream(file), path, xbstream.CatOptions{SpillDir: opts.spillDir}); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// Chain the tools so the output of each is the input of the next, the last writing to stdout
+	stdin, err := cmds[0].StdinPipe()
+	if err != nil {
+		fatal(err)
+	}
+	for i, cmd := range cmds {
+		cmd.Stderr = os.Stderr
+		if i == len(cmds)-1 {
+			cmd.Stdout = os.Stdout
+		} else if cmds[i+1].Stdin, err = cmd.StdoutPipe(); err != nil {
+			fatal(err)
+		}
+	}
+	for _, cmd := range cmds {
+		if err = cmd.Start(); err != nil {
+			fatal(err)
+		}
+	}
+
+	catErr := xbstream.Cat(stdin, openStream(file), path, xbstream.CatOptions{SpillDir: opts.spillDir})
+	stdin.Close()
+
+	var toolErr error
+	for _, cmd := range cmds {
+		if err = cmd.Wait(); err != nil && toolErr == nil {
+			toolErr = fmt.Errorf("%s: %v", cmd.Path, err)
+		}
+	}
+
+	if catErr != nil {
+		fatal(catErr)
+	}
+	if toolErr != nil {
+		fatal(toolErr)
+	}
+}
+
+// catPipeline returns the external tools that undo the encryption and compression of path, chosen by its
+// suffixes from the outermost inwards as xtrabackup applies compression before encryption
+func catPipeline(path string, opts catOptions) ([]*exec.Cmd, error) {
+	var cmds []*exec.Cmd
+
+	if strings.HasSuffix(path, ".xbcrypt") && opts.decrypt {
+		if opts.encryptKeyFile == "" {
+			return nil, fmt.Errorf("%s: --encrypt-key-file is required to decrypt", path)
+		}
+		cmds = append(cmds, exec.Command("xbcrypt", "--decrypt", "--encrypt-algo="+opts.encryptAlgo,
+			"--encrypt-key-file="+opts.encryptKeyFile))
+		path = strings.TrimSuffix(path, ".xbcrypt")
+	}
+
+	if opts.decompress {
+		switch {
+		case strings.HasSuffix(path, ".qp"):
+			cmds = append(cmds, exec.Command("qpress", "-dio"))
+		case strings.HasSuffix(path, ".zst"):
+			cmds = append(cmds, exec.Command("zstd", "-d", "-c"))
+		case strings.HasSuffix(path, ".lz4"):
+			cmds = append(cmds, exec.Command("lz4", "-d", "-c"))
+		}
+	}
+
+	return cmds, nil
+}
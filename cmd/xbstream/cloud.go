@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// putBackup uploads the archive in file to the store directory as backup, in the object layout of xbcloud
+func putBackup(file *os.File, store, backup string) {
+	if *file == (os.File{}) {
+		file = os.Stdin
+	}
+
+	if err := xbstream.Upload(xbstream.NewDirStore(store), backup, openStream(file)); err != nil {
+		fatal(fmt.Errorf("put: %w", err))
+	}
+}
+
+// getBackup downloads backup from the store directory and writes it to output as an archive
+func getBackup(output *os.File, store, backup string) {
+	if *output == (os.File{}) {
+		output = os.Stdout
+	}
+
+	w := xbstream.NewWriter(output)
+	if err := xbstream.Download(w, xbstream.NewDirStore(store), backup); err != nil {
+		fatal(fmt.Errorf("get: %w", err))
+	}
+	if err := w.Close(); err != nil {
+		fatal(err)
+	}
+}
+
+// deleteBackup removes every object of backup from the store directory
+func deleteBackup(store, backup string) {
+	if err := xbstream.DeleteBackup(xbstream.NewDirStore(store), backup); err != nil {
+		fatal(fmt.Errorf("delete: %w", err))
+	}
+}
+
+// listBackups prints the names of the backups held in the store directory
+func listBackups(store, format string) {
+	backups, err := xbstream.ListBackups(xbstream.NewDirStore(store))
+	if err != nil {
+		fatal(err)
+	}
+
+	switch format {
+	case "json":
+		if backups == nil {
+			backups = []string{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(backups)
+	default:
+		for _, backup := range backups {
+			fmt.Println(backup)
+		}
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// backupStream returns a Reader over backup as it is downloaded from the store directory
+func backupStream(store, backup string) *xbstream.Reader {
+	r, w := io.Pipe()
+	go func() {
+		err := xbstream.Download(xbstream.NewWriter(w), xbstream.NewDirStore(store), backup)
+		if err != nil {
+			log.Print(err)
+			os.Exit(exitCode(err))
+		}
+		w.Close()
+	}()
+	return xbstream.NewReader(r)
+}
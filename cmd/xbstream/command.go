@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// commandRunner pipes each extracted member into a shell command, in the manner of tar --to-command
+type commandRunner struct {
+	sink   *xbstream.CommandSink
+	failed int32 // commands that failed when failures are only reported
+}
+
+// newCommandRunner returns a runner of command in dir. The sizes of the members are indexed up front when file
+// is seekable, otherwise each member is buffered until it is complete. onError is fail, warn or ignore.
+func newCommandRunner(file *os.File, command, dir string, parallel int, onError string) *commandRunner {
+	if *file == (os.File{}) {
+		file = os.Stdin
+	}
+
+	c := &commandRunner{}
+	c.sink = &xbstream.CommandSink{
+		Command: func(path string, size int64) *exec.Cmd {
+			cmd := exec.Command("/bin/sh", "-c", command)
+			cmd.Dir = dir
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			cmd.Env = append(os.Environ(), "XBSTREAM_FILENAME="+path, "XBSTREAM_SIZE="+strconv.FormatInt(size, 10))
+			return cmd
+		},
+		Parallel: parallel,
+	}
+
+	if entries := indexStream(file); entries != nil {
+		c.sink.Sizes = make(map[string]int64, len(entries))
+		for _, entry := range entries {
+			c.sink.Sizes[entry.Path] = entry.Size
+		}
+	}
+
+	switch onError {
+	case "warn":
+		c.sink.ExitStatus = func(path string, err error) error {
+			log.Printf("%s: %v", path, err)
+			atomic.AddInt32(&c.failed, 1)
+			return nil
+		}
+	case "ignore":
+		c.sink.ExitStatus = func(string, error) error { return nil }
+	}
+
+	return c
+}
+
+// finish exits with exitPartial when any reported command failed
+func (c *commandRunner) finish() {
+	if n := atomic.LoadInt32(&c.failed); n > 0 {
+		log.Printf("%d commands failed", n)
+		os.Exit(exitPartial)
+	}
+}
+
+// indexStream indexes the archive in file and rewinds it, returning nil when file can not be rewound
+func indexStream(file *os.File) []*xbstream.IndexEntry {
+	if _, err := file.Seek(0, io.SeekCurrent); err != nil {
+		return nil
+	}
+
+	entries, err := xbstream.BuildIndex(openStream(file))
+	if _, serr := file.Seek(0, io.SeekStart); serr != nil {
+		fatal(serr)
+	}
+	if err != nil {
+		// The extraction reports the problem with the archive
+		return nil
+	}
+	return entries
+}
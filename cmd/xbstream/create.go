@@ -0,0 +1,258 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// sourceError is a failure to read a file being archived, which --keep-going reports instead of failing
+type sourceError struct {
+	path    string
+	partial bool // some of the file was written to the archive before the failure
+	err     error
+}
+
+func (e *sourceError) Error() string {
+	return fmt.Sprintf("%s: %v", e.path, e.err)
+}
+
+func (e *sourceError) Unwrap() error {
+	return e.err
+}
+
+// createOptions holds the command line options of create
+type createOptions struct {
+	output     string // archive written, stdout when empty
+	volumeSize int64  // splits the archive into volumes named after output when non-zero
+	parallel   int
+	keepGoing  bool
+	progress   bool
+	throttle   *throttle
+}
+
+// writeStream archives the input files using parallel workers. Each worker holds a
+// single read buffer and at most one open source file. The first failure, or an interrupt, cancels the
+// remaining work after the chunks being written have completed so the archive never holds a partial chunk.
+func writeStream(input []string, opts createOptions) {
+	w, err := createWriter(opts.output, opts.volumeSize)
+	if err != nil {
+		fatal(err)
+	}
+
+	parallel := opts.parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	w.SetRateLimit(opts.throttle.writeLimiter())
+	reads := opts.throttle.readLimiter()
+
+	var meter *progressMeter
+	if opts.progress {
+		meter = newProgressMeter(inputSize(input))
+		w.SetProgress(meter.update)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts.throttle.watch(ctx)
+
+	var (
+		mutex   sync.Mutex
+		failure error
+		skipped []*sourceError
+	)
+
+	fail := func(err error) {
+		mutex.Lock()
+		if failure == nil {
+			failure = err
+		}
+		mutex.Unlock()
+		cancel()
+	}
+
+	report := func(err error) {
+		var srcErr *sourceError
+		if !opts.keepGoing || !errors.As(err, &srcErr) {
+			fail(err)
+			return
+		}
+
+		log.Printf("skipping %v", srcErr)
+		mutex.Lock()
+		skipped = append(skipped, srcErr)
+		mutex.Unlock()
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		select {
+		case <-interrupts:
+			fail(errors.New("interrupted"))
+		case <-ctx.Done():
+		}
+	}()
+
+	paths := make(chan string, parallel)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			b := make([]byte, xbstream.MinimumChunkSize)
+			for path := range paths {
+				if err := archiveFile(ctx, w, path, b, reads); err != nil {
+					report(err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, path := range input {
+		select {
+		case paths <- path:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(paths)
+
+	wg.Wait()
+
+	if err := w.Close(); err != nil {
+		fail(err)
+	}
+
+	if meter != nil {
+		meter.stop()
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if failure != nil {
+		fatal(failure)
+	}
+
+	if len(skipped) > 0 {
+		for _, srcErr := range skipped {
+			if srcErr.partial {
+				log.Printf("partially archived: %s", srcErr.path)
+			} else {
+				log.Printf("not archived: %s", srcErr.path)
+			}
+		}
+		log.Printf("%d files could not be archived", len(skipped))
+		os.Exit(exitPartial)
+	}
+}
+
+// inputSize returns the total size of the input files, ignoring any that can not be read
+func inputSize(input []string) int64 {
+	var total int64
+	for _, path := range input {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// createWriter opens the archive written by create, stdout when output is empty
+func createWriter(output string, volumeSize int64) (*xbstream.Writer, error) {
+	if volumeSize > 0 {
+		return xbstream.NewVolumeWriter(func(volume int) (io.WriteCloser, error) {
+			return os.OpenFile(xbstream.VolumeName(output, volume), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+		}, volumeSize)
+	}
+
+	if output == "" {
+		return xbstream.NewWriter(os.Stdout), nil
+	}
+
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return xbstream.NewWriter(file), nil
+}
+
+// archiveFile copies the file at path into the archive using b as its read buffer, limiting the rate it is
+// read at by reads. Failures reading the source file are returned as a *sourceError.
+func archiveFile(ctx context.Context, w *xbstream.Writer, path string, b []byte, reads *xbstream.RateLimiter) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return &sourceError{path: path, err: err}
+	}
+	defer file.Close()
+
+	fw, err := w.Create(filepath.ToSlash(path))
+	if err != nil {
+		return err
+	}
+
+	written := false
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := file.Read(b)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			// Without an EOF chunk readers of the archive see the file as incomplete
+			if aerr := fw.Abort(); aerr != nil {
+				return aerr
+			}
+			return &sourceError{path: path, partial: written, err: err}
+		}
+
+		if err = reads.Wait(ctx, n); err != nil {
+			return err
+		}
+
+		if _, err = fw.Write(b[:n]); err != nil {
+			return err
+		}
+		written = true
+	}
+
+	return fw.Close()
+}
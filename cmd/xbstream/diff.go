@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// diffStreams reports the files that differ between two archives, exiting with exitFailure when any do
+func diffStreams(a, b *os.File, format string, ranges bool) {
+	diffs, err := xbstream.Diff(openStream(a), openStream(b))
+	if err != nil {
+		fatal(err)
+	}
+
+	if format == "json" {
+		if diffs == nil {
+			diffs = []*xbstream.FileDiff{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(diffs); err != nil {
+			fatal(err)
+		}
+	} else {
+		for _, diff := range diffs {
+			switch diff.Kind {
+			case xbstream.DiffAdded:
+				fmt.Printf("added    %s (%d bytes)\n", diff.Path, diff.SizeB)
+			case xbstream.DiffRemoved:
+				fmt.Printf("removed  %s (%d bytes)\n", diff.Path, diff.SizeA)
+			default:
+				fmt.Printf("changed  %s (%d -> %d bytes)\n", diff.Path, diff.SizeA, diff.SizeB)
+			}
+			if ranges {
+				for _, r := range diff.Ranges {
+					fmt.Printf("         bytes %d-%d\n", r.Offset, r.Offset+r.Length-1)
+				}
+			}
+		}
+	}
+
+	if len(diffs) > 0 {
+		os.Exit(exitFailure)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// Exit codes shared by every command. verify additionally reports the class of problem found through the
+// codes in verifyExitCodes.
+const (
+	exitFailure = 1 // unclassified failure, or findings reported by an inspection command
+	exitUsage   = 2 // invalid command line
+	exitIO      = 3 // reading or writing a file or stream failed
+	exitCorrupt = 4 // the archive, or a backup stored within it, is corrupt or inconsistent
+	exitPartial = 5 // the command completed but skipped some files or commands failed, see --keep-going
+)
+
+// fatal logs err and exits with the exit code matching its class
+func fatal(err error) {
+	log.Print(err)
+	os.Exit(exitCode(err))
+}
+
+func exitCode(err error) int {
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+
+	switch {
+	case errors.Is(err, xbstream.ErrTruncated),
+		errors.Is(err, xbstream.ErrWrongMagic),
+		errors.Is(err, xbstream.ErrUnknownChunkType),
+		errors.Is(err, xbstream.ErrChecksum),
+		errors.Is(err, xbstream.ErrPathLength),
+		errors.Is(err, xbstream.ErrPayloadLength),
+		errors.Is(err, xbstream.ErrVolumeSequence):
+		return exitCorrupt
+	case errors.Is(err, xbstream.ErrStreamRead), errors.As(err, &pathErr), errors.As(err, &linkErr):
+		return exitIO
+	default:
+		return exitFailure
+	}
+}
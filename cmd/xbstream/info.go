@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// archiveInfo prints a summary of the archive and the backup it contains
+func archiveInfo(file *os.File, format string) {
+	if *file == (os.File{}) {
+		file = os.Stdin
+	}
+
+	info, err := xbstream.Summarize(openStream(file))
+	if err != nil {
+		fatal(err)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(info); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	fmt.Printf("files:           %d\n", info.Files)
+	fmt.Printf("chunks:          %d\n", info.Chunks)
+	fmt.Printf("logical bytes:   %d\n", info.LogicalBytes)
+	fmt.Printf("payload bytes:   %d\n", info.PayloadBytes)
+	fmt.Printf("stream bytes:    %d\n", info.StreamBytes)
+	fmt.Printf("interleaving:    %d\n", info.Interleaving)
+	fmt.Printf("compressed:      %d\n", info.Compressed)
+	fmt.Printf("encrypted:       %d\n", info.Encrypted)
+
+	fmt.Println("chunk sizes:")
+	for _, bucket := range info.ChunkSizes {
+		if bucket.Count == 0 {
+			continue
+		}
+		if bucket.Max == 0 {
+			fmt.Printf("  > %-12d %d\n", bucket.Min, bucket.Count)
+		} else {
+			fmt.Printf("  <= %-11d %d\n", bucket.Max, bucket.Count)
+		}
+	}
+
+	if b := info.Backup; b != nil {
+		fmt.Printf("backup type:     %s\n", b.Type)
+		fmt.Printf("from lsn:        %d\n", b.FromLSN)
+		fmt.Printf("to lsn:          %d\n", b.ToLSN)
+		fmt.Printf("last lsn:        %d\n", b.LastLSN)
+		fmt.Printf("server version:  %s\n", b.ServerVersion)
+		fmt.Printf("tool version:    %s\n", b.ToolVersion)
+		fmt.Printf("start time:      %s\n", b.StartTime)
+		fmt.Printf("end time:        %s\n", b.EndTime)
+		if b.BinlogFile != "" {
+			fmt.Printf("binlog position: %s:%d\n", b.BinlogFile, b.BinlogPosition)
+		}
+		if b.GTID != "" {
+			fmt.Printf("gtid:            %s\n", b.GTID)
+		}
+	}
+
+	for _, warning := range info.Warnings {
+		fmt.Printf("warning:         %s\n", warning)
+	}
+}
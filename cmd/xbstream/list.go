@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// listStream prints every file stored in the archive with its size and chunk statistics. With a store directory
+// the archive is the backup downloaded from it, or the names of the stored backups when no backup is given.
+func listStream(file *os.File, format, store, backup string) {
+	if *file == (os.File{}) {
+		file = os.Stdin
+	}
+
+	if store != "" && backup == "" {
+		listBackups(store, format)
+		return
+	}
+
+	var r *xbstream.Reader
+	if store != "" {
+		r = backupStream(store, backup)
+	} else {
+		r = openStream(file)
+	}
+
+	entries, err := xbstream.BuildIndex(r)
+	if err != nil {
+		fatal(err)
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(entries)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"path", "size", "chunks", "first_offset", "last_offset", "eof"})
+		for _, entry := range entries {
+			w.Write([]string{
+				entry.Path,
+				strconv.FormatInt(entry.Size, 10),
+				strconv.Itoa(entry.Chunks),
+				strconv.FormatInt(entry.FirstOffset, 10),
+				strconv.FormatInt(entry.LastOffset, 10),
+				strconv.FormatBool(entry.EOF),
+			})
+		}
+		w.Flush()
+		err = w.Error()
+	default:
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "PATH\tSIZE\tCHUNKS\tFIRST OFFSET\tLAST OFFSET\tEOF")
+		for _, entry := range entries {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%t\n", entry.Path, entry.Size, entry.Chunks, entry.FirstOffset, entry.LastOffset, entry.EOF)
+		}
+		err = tw.Flush()
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+}
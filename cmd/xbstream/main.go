@@ -20,13 +20,13 @@
 package main
 
 import (
-	"encoding/binary"
-	"hash/crc32"
-	"io"
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
-	"path/filepath"
-	"sync"
+	"os/signal"
+	"strings"
 
 	"github.com/akamensky/argparse"
 	"github.com/skmcgrail/go-xbstream/xbstream"
@@ -40,148 +40,348 @@ func main() {
 	parser := argparse.NewParser("xbstream", "Go implementation of the xbstream archive format")
 
 	createCmd := parser.NewCommand("create", "create xbstream archive")
-	createFile := createCmd.File("o", "output", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666, &argparse.Options{})
+	createOut := createCmd.String("o", "output", &argparse.Options{Help: "output archive, the base name of the volumes when splitting"})
 	createList := createCmd.List("i", "input", &argparse.Options{Required: true})
+	createParallel := createCmd.Int("p", "parallel", &argparse.Options{Default: 1, Help: "number of files archived concurrently, which also caps the open source files"})
+	createKeepGoing := createCmd.Flag("k", "keep-going", &argparse.Options{Help: "skip files that can not be read and report them instead of failing, a file that fails part way is stored without its EOF chunk"})
+	createVolume := createCmd.String("", "volume-size", &argparse.Options{Help: "split the archive into numbered volumes of at most this size, such as 1G"})
+	createProgress := createCmd.Flag("", "progress", &argparse.Options{Help: "report progress on stderr"})
+	createReadRate := createCmd.String("", "read-rate", &argparse.Options{Help: "limit reads of the source files to this many bytes per second, such as 50M"})
+	createWriteRate := createCmd.String("", "write-rate", &argparse.Options{Help: "limit writes of the archive to this many bytes per second"})
+	createBurst := createCmd.String("", "burst", &argparse.Options{Help: "bytes that may exceed the rate after a pause, defaults to one second of the rate"})
+	createRateFile := createCmd.String("", "rate-file", &argparse.Options{Help: "control file of read, write and burst limits, reloaded when changed or on SIGHUP"})
 
 	extractCmd := parser.NewCommand("extract", "extract xbstream archive")
 	extractFile := extractCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
 	extractOut := extractCmd.String("o", "output", &argparse.Options{})
+	extractParallel := extractCmd.Int("p", "parallel", &argparse.Options{Default: 1, Help: "number of workers writing files"})
+	extractTables := extractCmd.List("t", "tables", &argparse.Options{Help: "only extract the tables matching schema.table, comma separated globs or ~regex"})
+	extractInclude := extractCmd.List("", "include", &argparse.Options{Help: "only extract paths matching a glob or ~regex"})
+	extractExclude := extractCmd.List("", "exclude", &argparse.Options{Help: "do not extract paths matching a glob or ~regex"})
+	extractVerify := extractCmd.Flag("", "verify-skipped", &argparse.Options{Help: "checksum the chunks of files that are not extracted"})
+	extractWriteRate := extractCmd.String("", "write-rate", &argparse.Options{Help: "limit writes of the extracted files to this many bytes per second, such as 50M"})
+	extractBurst := extractCmd.String("", "burst", &argparse.Options{Help: "bytes that may exceed the rate after a pause, defaults to one second of the rate"})
+	extractRateFile := extractCmd.String("", "rate-file", &argparse.Options{Help: "control file of write and burst limits, reloaded when changed or on SIGHUP"})
+	extractDurable := extractCmd.Flag("", "durable", &argparse.Options{Help: "extract into a staging directory, sync everything and rename it to the output directory, which must not exist or be empty"})
+	extractJournal := extractCmd.String("", "journal", &argparse.Options{Help: "record extracted files and chunks in this file so an interrupted extraction can be resumed by running it again, removed once extraction succeeds"})
+	extractCommand := extractCmd.String("", "to-command", &argparse.Options{Help: "pipe each file into this shell command, run in the output directory with XBSTREAM_FILENAME and XBSTREAM_SIZE set, instead of writing it"})
+	extractCommandParallel := extractCmd.Int("", "command-parallel", &argparse.Options{Default: 0, Help: "maximum number of commands running at once, unlimited when 0"})
+	extractCommandErrors := extractCmd.Selector("", "command-errors", []string{"fail", "warn", "ignore"}, &argparse.Options{Default: "fail", Help: "stop at the first failed command, report failures and exit 5, or ignore them"})
+	extractProgress := extractCmd.Flag("", "progress", &argparse.Options{Help: "report progress on stderr, with an ETA when the input can be indexed first"})
+
+	listCmd := parser.NewCommand("list", "list the files stored in an xbstream archive")
+	listFile := listCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	listFormat := listCmd.Selector("f", "format", []string{"text", "json", "csv"}, &argparse.Options{Default: "text"})
+	listStore := listCmd.String("", "store", &argparse.Options{Help: "list the backups in this object store directory instead of an archive"})
+	listBackup := listCmd.String("", "backup", &argparse.Options{Help: "with --store, list the files of this backup"})
+
+	verifyCmd := parser.NewCommand("verify", "check the structure and checksums of an xbstream archive")
+	verifyFile := verifyCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	verifyQuiet := verifyCmd.Flag("q", "quiet", &argparse.Options{Help: "only report problems through the exit status"})
+
+	deltaCmd := parser.NewCommand("apply-delta", "apply incremental deltas from an xbstream archive onto extracted tablespaces")
+	deltaFile := deltaCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	deltaOut := deltaCmd.String("o", "output", &argparse.Options{Required: true})
+
+	redoCmd := parser.NewCommand("redo-log", "inspect the xtrabackup_logfile header of an xbstream archive")
+	redoFile := redoCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	redoFormat := redoCmd.Selector("f", "format", []string{"text", "json"}, &argparse.Options{Default: "text"})
+
+	spaceCmd := parser.NewCommand("tablespaces", "list the InnoDB tablespaces stored in an xbstream archive")
+	spaceFile := spaceCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	spaceFormat := spaceCmd.Selector("f", "format", []string{"text", "json"}, &argparse.Options{Default: "text"})
+	spacePageSize := spaceCmd.Int("p", "page-size", &argparse.Options{Help: "expected page size, defaults to the most common page size"})
+
+	sdiCmd := parser.NewCommand("sdi", "list the table definitions stored as SDI in an xbstream archive")
+	sdiFile := sdiCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	sdiFormat := sdiCmd.Selector("f", "format", []string{"text", "json"}, &argparse.Options{Default: "text"})
+
+	toTarCmd := parser.NewCommand("to-tar", "convert an xbstream archive into a tar archive")
+	toTarIn := toTarCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	toTarOut := toTarCmd.File("o", "output", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666, &argparse.Options{})
+	toTarSpill := toTarCmd.String("", "spill-dir", &argparse.Options{Help: "directory for files too large to reassemble in memory, defaults to the system temporary directory"})
+	toTarThreshold := toTarCmd.Int("", "spill-threshold", &argparse.Options{Default: xbstream.MinimumChunkSize, Help: "size in bytes above which a file is spilled to disk"})
+
+	fromTarCmd := parser.NewCommand("from-tar", "convert a tar archive into an xbstream archive")
+	fromTarIn := fromTarCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	fromTarOut := fromTarCmd.File("o", "output", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666, &argparse.Options{})
+
+	catCmd := parser.NewCommand("cat", "write a single file stored in an xbstream archive to stdout")
+	catFile := catCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	catPath := catCmd.String("p", "path", &argparse.Options{Required: true, Help: "path of the file within the archive"})
+	catDecompress := catCmd.Flag("d", "decompress", &argparse.Options{Help: "decompress .qp, .zst and .lz4 files with qpress, zstd or lz4"})
+	catDecrypt := catCmd.Flag("", "decrypt", &argparse.Options{Help: "decrypt .xbcrypt files with xbcrypt"})
+	catAlgo := catCmd.Selector("", "encrypt-algo", []string{"AES128", "AES192", "AES256"}, &argparse.Options{Default: "AES256"})
+	catKeyFile := catCmd.String("", "encrypt-key-file", &argparse.Options{Help: "key file passed to xbcrypt"})
+	catSpill := catCmd.String("", "spill-dir", &argparse.Options{Help: "directory for chunks stored out of order, defaults to the system temporary directory"})
+
+	infoCmd := parser.NewCommand("info", "summarise an xbstream archive and the backup it contains")
+	infoFile := infoCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	infoFormat := infoCmd.Selector("f", "format", []string{"text", "json"}, &argparse.Options{Default: "text"})
+
+	diffCmd := parser.NewCommand("diff", "compare the files stored in two xbstream archives")
+	diffA := diffCmd.File("a", "old", os.O_RDONLY, 0600, &argparse.Options{Required: true, Help: "archive to compare against"})
+	diffB := diffCmd.File("b", "new", os.O_RDONLY, 0600, &argparse.Options{Required: true, Help: "archive compared"})
+	diffFormat := diffCmd.Selector("f", "format", []string{"text", "json"}, &argparse.Options{Default: "text"})
+	diffRanges := diffCmd.Flag("r", "ranges", &argparse.Options{Help: "report the byte ranges of changed files that differ"})
+
+	mergeCmd := parser.NewCommand("merge", "combine several xbstream archives into one")
+	mergeOut := mergeCmd.File("o", "output", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666, &argparse.Options{})
+	mergeList := mergeCmd.List("i", "input", &argparse.Options{Required: true, Help: "archives to merge, in order"})
+	mergePrefix := mergeCmd.List("", "prefix", &argparse.Options{Help: "path prefix for the files of each input, given once per input"})
+	mergeRename := mergeCmd.List("", "rename", &argparse.Options{Help: "store the file FROM as TO, given as FROM=TO"})
+
+	repairCmd := parser.NewCommand("repair", "copy the valid chunks of a damaged xbstream archive into a new archive")
+	repairIn := repairCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	repairOut := repairCmd.File("o", "output", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666, &argparse.Options{Required: true})
+	repairFormat := repairCmd.Selector("f", "format", []string{"text", "json"}, &argparse.Options{Default: "text"})
+
+	putCmd := parser.NewCommand("put", "store an xbstream archive in an object store using the layout of xbcloud")
+	putFile := putCmd.File("i", "input", os.O_RDONLY, 0600, &argparse.Options{})
+	putStore := putCmd.String("", "store", &argparse.Options{Required: true, Help: "object store directory"})
+	putBackupName := putCmd.String("", "backup", &argparse.Options{Required: true, Help: "name of the backup within the store"})
+
+	getCmd := parser.NewCommand("get", "write a backup held in an object store as an xbstream archive")
+	getOut := getCmd.File("o", "output", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666, &argparse.Options{})
+	getStore := getCmd.String("", "store", &argparse.Options{Required: true, Help: "object store directory"})
+	getBackupName := getCmd.String("", "backup", &argparse.Options{Required: true, Help: "name of the backup within the store"})
+
+	deleteCmd := parser.NewCommand("delete", "remove a backup from an object store")
+	deleteStore := deleteCmd.String("", "store", &argparse.Options{Required: true, Help: "object store directory"})
+	deleteBackupName := deleteCmd.String("", "backup", &argparse.Options{Required: true, Help: "name of the backup within the store"})
 
 	if err := parser.Parse(os.Args); err != nil {
-		log.Fatal(err)
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			fatal(err)
+		}
+		log.Print(err)
+		fmt.Fprint(os.Stderr, parser.Usage(nil))
+		os.Exit(exitUsage)
 	}
 
 	if createCmd.Happened() {
-		writeStream(createFile, createList)
+		var volumeSize int64
+		if *createVolume != "" {
+			var err error
+			if volumeSize, err = parseSize(*createVolume); err != nil {
+				log.Print(err)
+				os.Exit(exitUsage)
+			}
+			if volumeSize < xbstream.MinimumVolumeSize {
+				log.Printf("--volume-size must be at least %d bytes", xbstream.MinimumVolumeSize)
+				os.Exit(exitUsage)
+			}
+			if *createOut == "" {
+				log.Print("--volume-size requires --output")
+				os.Exit(exitUsage)
+			}
+		}
+		t, err := newThrottle(*createReadRate, *createWriteRate, *createBurst, *createRateFile)
+		if err != nil {
+			log.Print(err)
+			os.Exit(exitUsage)
+		}
+
+		writeStream(*createList, createOptions{
+			output:     *createOut,
+			volumeSize: volumeSize,
+			parallel:   *createParallel,
+			keepGoing:  *createKeepGoing,
+			progress:   *createProgress,
+			throttle:   t,
+		})
 	} else if extractCmd.Happened() {
-		readStream(extractFile, *extractOut)
+		filter, err := extractFilter(*extractTables, *extractInclude, *extractExclude)
+		if err != nil {
+			log.Print(err)
+			os.Exit(exitUsage)
+		}
+
+		t, err := newThrottle("", *extractWriteRate, *extractBurst, *extractRateFile)
+		if err != nil {
+			log.Print(err)
+			os.Exit(exitUsage)
+		}
+		t.watch(context.Background())
+
+		e := &xbstream.Extractor{Parallel: *extractParallel, Filter: filter, VerifySkipped: *extractVerify, Limiter: t.writeLimiter(), Durable: *extractDurable, Journal: *extractJournal}
+
+		var runner *commandRunner
+		if *extractCommand != "" {
+			if *extractDurable || *extractJournal != "" {
+				log.Print("--to-command can not be combined with --durable or --journal")
+				os.Exit(exitUsage)
+			}
+			runner = newCommandRunner(extractFile, *extractCommand, *extractOut, *extractCommandParallel, *extractCommandErrors)
+			e.Sink = runner.sink
+		}
+
+		readStream(extractFile, *extractOut, e, *extractProgress)
+		if runner != nil {
+			runner.finish()
+		}
+	} else if listCmd.Happened() {
+		if *listBackup != "" && *listStore == "" {
+			log.Print("--backup requires --store")
+			os.Exit(exitUsage)
+		}
+		listStream(listFile, *listFormat, *listStore, *listBackup)
+	} else if verifyCmd.Happened() {
+		verifyStream(verifyFile, *verifyQuiet)
+	} else if deltaCmd.Happened() {
+		applyDelta(deltaFile, *deltaOut)
+	} else if redoCmd.Happened() {
+		inspectRedoLog(redoFile, *redoFormat)
+	} else if spaceCmd.Happened() {
+		inventoryTablespaces(spaceFile, *spaceFormat, *spacePageSize)
+	} else if sdiCmd.Happened() {
+		dumpSDI(sdiFile, *sdiFormat)
+	} else if toTarCmd.Happened() {
+		toTar(toTarIn, toTarOut, *toTarSpill, int64(*toTarThreshold))
+	} else if fromTarCmd.Happened() {
+		fromTar(fromTarIn, fromTarOut)
+	} else if catCmd.Happened() {
+		catMember(catFile, *catPath, catOptions{
+			decompress:     *catDecompress,
+			decrypt:        *catDecrypt,
+			encryptAlgo:    *catAlgo,
+			encryptKeyFile: *catKeyFile,
+			spillDir:       *catSpill,
+		})
+	} else if infoCmd.Happened() {
+		archiveInfo(infoFile, *infoFormat)
+	} else if diffCmd.Happened() {
+		diffStreams(diffA, diffB, *diffFormat, *diffRanges)
+	} else if mergeCmd.Happened() {
+		mergeStreams(mergeOut, *mergeList, *mergePrefix, *mergeRename)
+	} else if repairCmd.Happened() {
+		repairStream(repairIn, repairOut, *repairFormat)
+	} else if putCmd.Happened() {
+		putBackup(putFile, *putStore, *putBackupName)
+	} else if getCmd.Happened() {
+		getBackup(getOut, *getStore, *getBackupName)
+	} else if deleteCmd.Happened() {
+		deleteBackup(*deleteStore, *deleteBackupName)
 	}
 }
 
-func readStream(file *os.File, output string) {
+func readStream(file *os.File, output string, e *xbstream.Extractor, progress bool) {
 	var err error
 
 	if *file == (os.File{}) {
 		file = os.Stdin
 	}
 
+	var meter *progressMeter
+	if progress {
+		meter = newProgressMeter(streamSize(file))
+		e.Progress = meter.update
+	}
+
 	if output == "" {
 		output, err = os.Getwd()
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	}
 
-	if err = os.MkdirAll(output, 0777); err != nil {
-		log.Fatal(err)
+	// A durable extraction creates the directory itself, once the archive has been extracted in full
+	if !e.Durable {
+		if err = os.MkdirAll(output, 0777); err != nil {
+			fatal(err)
+		}
 	}
 
-	r := xbstream.NewReader(file)
-
-	files := make(map[string]*os.File)
-
-	var f *os.File
-	var ok bool
+	// The first interrupt stops the extraction so a durable extraction can remove its staging directory,
+	// a second one terminates the process
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		if _, ok := <-interrupts; ok {
+			signal.Stop(interrupts)
+			e.Cancel()
+		}
+	}()
+
+	e.Dir = output
+	err = e.Extract(openStream(file))
+	signal.Stop(interrupts)
+	close(interrupts)
+	if meter != nil {
+		meter.stop()
+	}
+	if err != nil {
+		fatal(err)
+	}
 
-	for {
-		chunk, err := r.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			log.Fatal(err)
-			break
+	// The journal is only needed to resume an extraction that did not finish
+	if e.Journal != "" {
+		if err = os.Remove(e.Journal); err != nil {
+			fatal(err)
 		}
+	}
+}
 
-		fPath := string(chunk.Path)
+// streamSize returns the payload bytes of the archive in file by indexing it first, or zero when file can not
+// be rewound afterwards. The volumes of a set can not be seeked over, so they are not indexed either.
+func streamSize(file *os.File) int64 {
+	if isVolumeSet(file) {
+		return 0
+	}
 
-		if f, ok = files[fPath]; !ok {
-			newFPath := filepath.Join(output, fPath)
-			if err = os.MkdirAll(filepath.Dir(newFPath), 0777); err != nil {
-				log.Fatal(err)
-				break
-			}
+	var total int64
+	for _, entry := range indexStream(file) {
+		total += entry.Size
+	}
+	return total
+}
 
-			f, err = os.OpenFile(newFPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
-			if err != nil {
-				log.Fatal(err)
-				break
-			}
-			files[fPath] = f
-		}
+// extractFilter combines the table and path selections of extract, returning nil when every file is extracted
+func extractFilter(tables, include, exclude []string) (func(string) bool, error) {
+	var filters []func(string) bool
 
-		if chunk.Type == xbstream.ChunkTypeEOF {
-			f.Close()
-			continue
+	if len(tables) > 0 {
+		var patterns []string
+		for _, t := range tables {
+			patterns = append(patterns, strings.Split(t, ",")...)
 		}
 
-		crc32Hash := crc32.NewIEEE()
-
-		tReader := io.TeeReader(chunk, crc32Hash)
-
-		f.Seek(int64(chunk.PayOffset), io.SeekStart)
-		if _, err = io.Copy(f, tReader); err != nil {
-			log.Fatal(err)
-			break
+		tableFilter, err := xbstream.NewTableFilter(patterns)
+		if err != nil {
+			return nil, err
 		}
+		filters = append(filters, tableFilter.Match)
+	}
 
-		if chunk.Checksum != binary.BigEndian.Uint32(crc32Hash.Sum(nil)) {
-			log.Fatal("chunk checksum did not match")
-			break
+	if len(include) > 0 || len(exclude) > 0 {
+		pathFilter, err := xbstream.NewPathFilter(include, exclude)
+		if err != nil {
+			return nil, err
 		}
+		filters = append(filters, pathFilter.Match)
 	}
+
+	if len(filters) == 0 {
+		return nil, nil
+	}
+
+	return func(path string) bool {
+		for _, filter := range filters {
+			if !filter(path) {
+				return false
+			}
+		}
+		return true
+	}, nil
 }
 
-func writeStream(file *os.File, input *[]string) {
+func applyDelta(file *os.File, output string) {
 	if *file == (os.File{}) {
-		file = os.Stdout
-	}
-
-	w := xbstream.NewWriter(file)
-
-	wg := sync.WaitGroup{}
-
-	for _, f := range *input {
-		wg.Add(1)
-		go func(path string) {
-			defer wg.Done()
-
-			b := make([]byte, xbstream.MinimumChunkSize)
-
-			if file, err := os.Open(path); err == nil {
-				fw, err := w.Create(path)
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				for {
-					n, err := file.Read(b)
-					if err != nil {
-						if err == io.EOF {
-							break
-						}
-						log.Fatal(err)
-					}
-					if _, err := fw.Write(b[:n]); err != nil {
-						log.Fatal(err)
-						break
-					}
-				}
-
-				err = fw.Close()
-				if err != nil {
-					log.Fatal(err)
-				}
-			} else {
-				log.Printf("unable to open file %s", file.Name())
-			}
-		}(f)
+		file = os.Stdin
 	}
 
-	wg.Wait()
-
-	err := w.Close()
-	if err != nil {
-		log.Fatal(err)
+	if err := xbstream.ApplyDeltas(openStream(file), output); err != nil {
+		fatal(err)
 	}
 }
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// mergeStreams combines the archives named by inputs into a single archive. prefixes, when given, holds one
+// prefix per input. renames holds FROM=TO pairs applied to the files of every input.
+func mergeStreams(output *os.File, inputs, prefixes, renames []string) {
+	if len(prefixes) > 0 && len(prefixes) != len(inputs) {
+		log.Printf("%d prefixes given for %d inputs", len(prefixes), len(inputs))
+		os.Exit(exitUsage)
+	}
+
+	rename := make(map[string]string)
+	for _, r := range renames {
+		kv := strings.SplitN(r, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			log.Printf("malformed rename %q, expected FROM=TO", r)
+			os.Exit(exitUsage)
+		}
+		rename[kv[0]] = kv[1]
+	}
+
+	if *output == (os.File{}) {
+		output = os.Stdout
+	}
+
+	var sources []xbstream.MergeSource
+	for i, input := range inputs {
+		file, err := os.Open(input)
+		if err != nil {
+			fatal(err)
+		}
+		defer file.Close()
+
+		source := xbstream.MergeSource{Reader: openStream(file), Rename: rename}
+		if len(prefixes) > 0 {
+			source.Prefix = prefixes[i]
+		}
+		sources = append(sources, source)
+	}
+
+	w := xbstream.NewWriter(output)
+	if err := xbstream.Merge(w, sources); err != nil {
+		fatal(fmt.Errorf("merge: %w", err))
+	}
+	if err := w.Close(); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// progressMeter renders the progress of create or extract to stderr, so it never mixes with an archive written
+// to stdout. A terminal gets a single line redrawn every second, anything else a new line every ten seconds.
+type progressMeter struct {
+	total    int64 // expected payload bytes, zero when unknown
+	start    time.Time
+	interval time.Duration
+	terminal bool
+
+	mutex    sync.Mutex
+	progress xbstream.Progress
+
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newProgressMeter(total int64) *progressMeter {
+	m := &progressMeter{total: total, start: time.Now(), interval: 10 * time.Second, done: make(chan struct{})}
+	if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		m.terminal = true
+		m.interval = time.Second
+	}
+
+	m.stopped.Add(1)
+	go func() {
+		defer m.stopped.Done()
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.render()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+
+	return m
+}
+
+// update records the latest progress, it is called for every chunk so only stores it
+func (m *progressMeter) update(p xbstream.Progress) {
+	m.mutex.Lock()
+	m.progress = p
+	m.mutex.Unlock()
+}
+
+// stop renders the final progress and ends the meter's line
+func (m *progressMeter) stop() {
+	close(m.done)
+	m.stopped.Wait()
+	m.render()
+	if m.terminal {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func (m *progressMeter) render() {
+	m.mutex.Lock()
+	p := m.progress
+	m.mutex.Unlock()
+
+	elapsed := time.Since(m.start)
+	rate := float64(p.Bytes) / elapsed.Seconds()
+
+	line := formatBytes(p.Bytes)
+	if m.total > 0 {
+		line += fmt.Sprintf(" / %s (%d%%)", formatBytes(m.total), p.Bytes*100/m.total)
+	}
+	line += fmt.Sprintf("  %s/s  %d files", formatBytes(int64(rate)), p.Files)
+	if m.total > 0 && rate > 0 && p.Bytes < m.total {
+		eta := time.Duration(float64(m.total-p.Bytes) / rate * float64(time.Second))
+		line += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+	}
+	line += "  " + p.Path
+
+	if m.terminal {
+		// Clear the rest of the previous line, which may have been longer
+		fmt.Fprintf(os.Stderr, "\r%s\033[K", line)
+	} else {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+// formatBytes formats n using binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+type redoLogReport struct {
+	RedoLog     *xbstream.RedoLogInfo
+	Checkpoints *xbstream.Checkpoints
+	Problems    []string
+}
+
+type redoLogResult struct {
+	info *xbstream.RedoLogInfo
+	err  error
+}
+
+// inspectRedoLog reports the header of the xtrabackup_logfile member and checks it against xtrabackup_checkpoints
+func inspectRedoLog(file *os.File, format string) {
+	if *file == (os.File{}) {
+		file = os.Stdin
+	}
+
+	r := openStream(file)
+
+	var (
+		pw          *io.PipeWriter
+		result      = make(chan redoLogResult, 1)
+		checkpoints = new(bytes.Buffer)
+		hasCheckpts bool
+		err         error
+	)
+
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			fatal(err)
+		}
+
+		if chunk.Type == xbstream.ChunkTypeUnknown {
+			continue
+		}
+
+		switch string(chunk.Path) {
+		case xbstream.CheckpointsFile:
+			hasCheckpts = true
+			if chunk.Type != xbstream.ChunkTypePayload {
+				continue
+			}
+			if _, err = io.Copy(checkpoints, chunk); err != nil {
+				fatal(err)
+			}
+		case xbstream.RedoLogFile:
+			if pw == nil {
+				var pr *io.PipeReader
+				pr, pw = io.Pipe()
+				go func() {
+					info, err := xbstream.InspectRedoLog(pr)
+					pr.CloseWithError(errors.New("redo log inspection finished"))
+					result <- redoLogResult{info, err}
+				}()
+			}
+
+			if chunk.Type == xbstream.ChunkTypeEOF {
+				pw.Close()
+				continue
+			}
+
+			// A failed write means the inspection has already returned, its result is reported below
+			io.Copy(pw, chunk)
+		}
+	}
+
+	if pw == nil {
+		fatal(fmt.Errorf("%s not found in archive", xbstream.RedoLogFile))
+	}
+	pw.Close()
+
+	res := <-result
+	if res.err != nil {
+		fatal(res.err)
+	}
+
+	report := redoLogReport{RedoLog: res.info, Problems: []string{}}
+	if hasCheckpts {
+		if report.Checkpoints, err = xbstream.ParseCheckpoints(checkpoints); err != nil {
+			fatal(err)
+		}
+		for _, problem := range res.info.Validate(report.Checkpoints) {
+			report.Problems = append(report.Problems, problem.Error())
+		}
+	} else {
+		report.Problems = append(report.Problems, xbstream.CheckpointsFile+" not found in archive")
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(report); err != nil {
+			fatal(err)
+		}
+	} else {
+		info := report.RedoLog
+		fmt.Printf("format:          %d\n", info.Format)
+		fmt.Printf("creator:         %s\n", info.Creator)
+		fmt.Printf("start lsn:       %d\n", info.StartLSN)
+		fmt.Printf("checkpoint no:   %d\n", info.CheckpointNo)
+		fmt.Printf("checkpoint lsn:  %d\n", info.CheckpointLSN)
+		fmt.Printf("end lsn:         %d\n", info.EndLSN)
+		fmt.Printf("blocks:          %d\n", info.Blocks)
+		if c := report.Checkpoints; c != nil {
+			fmt.Printf("backup type:     %s\n", c.BackupType)
+			fmt.Printf("from lsn:        %d\n", c.FromLSN)
+			fmt.Printf("to lsn:          %d\n", c.ToLSN)
+			fmt.Printf("last lsn:        %d\n", c.LastLSN)
+		}
+		for _, problem := range report.Problems {
+			fmt.Printf("problem:         %s\n", problem)
+		}
+	}
+
+	if len(report.Problems) > 0 {
+		os.Exit(exitCorrupt)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// repairStream salvages the valid chunks of a damaged archive into a new archive and reports the state of
+// each file. It exits with exitPartial when any file could not be fully recovered.
+func repairStream(input, output *os.File, format string) {
+	if *input == (os.File{}) {
+		input = os.Stdin
+	}
+
+	w := xbstream.NewWriter(output)
+	report, err := xbstream.Repair(w, openStream(input))
+	if err != nil {
+		fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		fatal(err)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(report); err != nil {
+			fatal(err)
+		}
+	} else {
+		for _, problem := range report.Damage {
+			fmt.Printf("damage   %s\n", problem)
+		}
+		for _, file := range report.Files {
+			fmt.Printf("%-8s %s (%d bytes", file.Status, file.Path, file.Size)
+			if file.Dropped > 0 {
+				fmt.Printf(", %d chunks dropped", file.Dropped)
+			}
+			if !file.EOF {
+				fmt.Print(", EOF missing")
+			}
+			fmt.Println(")")
+			for _, r := range file.Missing {
+				fmt.Printf("         missing bytes %d-%d\n", r.Offset, r.Offset+r.Length-1)
+			}
+		}
+	}
+
+	for _, file := range report.Files {
+		if file.Status != xbstream.RepairComplete {
+			os.Exit(exitPartial)
+		}
+	}
+}
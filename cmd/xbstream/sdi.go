@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+type sdiReport struct {
+	Path    string
+	Records []xbstream.SDIRecord `json:",omitempty"`
+	Error   string               `json:",omitempty"`
+}
+
+// dumpSDI lists the tables, columns and indexes described by the SDI of every tablespace in the archive
+func dumpSDI(file *os.File, format string) {
+	if *file == (os.File{}) {
+		file = os.Stdin
+	}
+
+	results, err := xbstream.ExtractSDI(openStream(file))
+	if err != nil {
+		fatal(err)
+	}
+
+	if format == "json" {
+		reports := make([]sdiReport, 0, len(results))
+		for _, result := range results {
+			report := sdiReport{Path: result.Path, Records: result.Records}
+			if result.Err != nil {
+				report.Error = result.Err.Error()
+			}
+			reports = append(reports, report)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(reports); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("%s: %v\n", result.Path, result.Err)
+			continue
+		}
+
+		for _, record := range result.Records {
+			if record.Type != xbstream.SDITypeTable {
+				continue
+			}
+
+			table, err := record.Table()
+			if err != nil {
+				fatal(fmt.Errorf("%s: %v", result.Path, err))
+			}
+
+			fmt.Printf("%s.%s (%s)\n", table.Schema, table.Name, result.Path)
+			for _, column := range table.Columns {
+				nullable := ""
+				if column.Nullable {
+					nullable = " null"
+				}
+				fmt.Printf("  column %s %s%s\n", column.Name, column.Type, nullable)
+			}
+			for _, index := range table.Indexes {
+				fmt.Printf("  index %s %s (%s)\n", index.Name, index.Type, strings.Join(index.Columns, ", "))
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+type tablespaceReport struct {
+	Tablespaces []*xbstream.TablespaceInfo
+	Duplicates  map[uint32][]string // space ids used by more than one tablespace
+	Encrypted   []string            // tablespaces that require keyring files to restore
+	Unexpected  []string            // tablespaces whose page size differs from the expected page size
+}
+
+// inventoryTablespaces reports the header of every tablespace in the archive and flags duplicate space ids,
+// encrypted tablespaces and unexpected page sizes. A pageSize of 0 expects the most common page size.
+func inventoryTablespaces(file *os.File, format string, pageSize int) {
+	if *file == (os.File{}) {
+		file = os.Stdin
+	}
+
+	infos, err := xbstream.InventoryTablespaces(openStream(file))
+	if err != nil {
+		fatal(err)
+	}
+
+	report := tablespaceReport{
+		Tablespaces: infos,
+		Duplicates:  make(map[uint32][]string),
+		Encrypted:   []string{},
+		Unexpected:  []string{},
+	}
+
+	spaces := make(map[uint32][]string)
+	pageSizes := make(map[int]int)
+	for _, info := range infos {
+		spaces[info.SpaceID] = append(spaces[info.SpaceID], info.Path)
+		pageSizes[info.PageSize]++
+		if info.Encrypted {
+			report.Encrypted = append(report.Encrypted, info.Path)
+		}
+	}
+
+	for id, paths := range spaces {
+		if len(paths) > 1 {
+			report.Duplicates[id] = paths
+		}
+	}
+
+	if pageSize == 0 {
+		for size, count := range pageSizes {
+			if count > pageSizes[pageSize] || (count == pageSizes[pageSize] && size > pageSize) {
+				pageSize = size
+			}
+		}
+	}
+	for _, info := range infos {
+		if info.PageSize != pageSize {
+			report.Unexpected = append(report.Unexpected, info.Path)
+		}
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(report); err != nil {
+			fatal(err)
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "PATH\tSPACE ID\tPAGE SIZE\tFLAGS\tSIZE")
+		for _, info := range infos {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%d\n", info.Path, info.SpaceID, info.PageSize, describeFlags(info), info.Size)
+		}
+		tw.Flush()
+
+		ids := make([]int, 0, len(report.Duplicates))
+		for id := range report.Duplicates {
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			fmt.Printf("duplicate space id %d: %s\n", id, strings.Join(report.Duplicates[uint32(id)], ", "))
+		}
+		for _, path := range report.Encrypted {
+			fmt.Printf("encrypted, keyring required: %s\n", path)
+		}
+		for _, path := range report.Unexpected {
+			fmt.Printf("unexpected page size, expected %d: %s\n", pageSize, path)
+		}
+	}
+
+	if len(report.Duplicates) > 0 || len(report.Unexpected) > 0 {
+		os.Exit(exitFailure)
+	}
+}
+
+func describeFlags(info *xbstream.TablespaceInfo) string {
+	var flags []string
+	if info.Compressed() {
+		flags = append(flags, fmt.Sprintf("compressed(%d)", info.ZipSize))
+	}
+	if info.Encrypted {
+		flags = append(flags, "encrypted")
+	}
+	if info.SDI {
+		flags = append(flags, "sdi")
+	}
+	if len(flags) == 0 {
+		return "-"
+	}
+	return strings.Join(flags, ",")
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"os"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// toTar converts the archive read from input into a tar stream written to output
+func toTar(input, output *os.File, spillDir string, threshold int64) {
+	if *input == (os.File{}) {
+		input = os.Stdin
+	}
+	if *output == (os.File{}) {
+		output = os.Stdout
+	}
+
+	opts := xbstream.TarOptions{SpillDir: spillDir, SpillThreshold: threshold}
+	if err := xbstream.ToTar(output, openStream(input), opts); err != nil {
+		fatal(err)
+	}
+	if err := output.Close(); err != nil {
+		fatal(err)
+	}
+}
+
+// fromTar converts the tar stream read from input into an archive written to output
+func fromTar(input, output *os.File) {
+	if *input == (os.File{}) {
+		input = os.Stdin
+	}
+	if *output == (os.File{}) {
+		output = os.Stdout
+	}
+
+	w := xbstream.NewWriter(output)
+	if err := xbstream.FromTar(w, input); err != nil {
+		fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		fatal(err)
+	}
+}
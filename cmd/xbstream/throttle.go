@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// throttlePoll is how often the control file is checked for changes
+const throttlePoll = time.Second
+
+// throttle holds the rate limiters of a command. The limits given on the command line can be replaced at
+// runtime through a control file of "read = 10M", "write = 50M" and "burst = 1M" lines, which is reloaded
+// when it changes or when the process receives SIGHUP. A rate of 0 removes the limit.
+type throttle struct {
+	read    *xbstream.RateLimiter
+	write   *xbstream.RateLimiter
+	rates   map[string]int64
+	file    string
+	modTime time.Time
+}
+
+// newThrottle parses the rate options of a command, returning nil when no limit can ever apply
+func newThrottle(read, write, burst, file string) (*throttle, error) {
+	if read == "" && write == "" && file == "" {
+		return nil, nil
+	}
+
+	t := &throttle{rates: make(map[string]int64), file: file}
+	for _, option := range []struct{ key, flag, value string }{
+		{"read", "--read-rate", read},
+		{"write", "--write-rate", write},
+		{"burst", "--burst", burst},
+	} {
+		if option.value == "" {
+			continue
+		}
+		rate, err := parseSize(option.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", option.flag, err)
+		}
+		t.rates[option.key] = rate
+	}
+
+	t.read = xbstream.NewRateLimiter(t.rates["read"], t.rates["burst"])
+	t.write = xbstream.NewRateLimiter(t.rates["write"], t.rates["burst"])
+
+	if file != "" {
+		if err := t.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// watch reloads the control file on SIGHUP or when it is modified, until ctx is done
+func (t *throttle) watch(ctx context.Context) {
+	if t == nil || t.file == "" {
+		return
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hangups)
+		ticker := time.NewTicker(throttlePoll)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-hangups:
+				t.modTime = time.Time{}
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			if err := t.reload(); err != nil {
+				log.Printf("rate limits unchanged: %v", err)
+			}
+		}
+	}()
+}
+
+// reload applies the control file when it has been modified since it was last read
+func (t *throttle) reload() error {
+	info, err := os.Stat(t.file)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(t.modTime) {
+		return nil
+	}
+
+	file, err := os.Open(t.file)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rates := make(map[string]int64)
+	for k, v := range t.rates {
+		rates[k] = v
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || (key != "read" && key != "write" && key != "burst") {
+			return fmt.Errorf("%s: malformed line %q", t.file, line)
+		}
+		if rates[key], err = parseSize(strings.TrimSpace(kv[1])); err != nil {
+			return fmt.Errorf("%s: %v", t.file, err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	t.rates = rates
+	t.modTime = info.ModTime()
+	t.read.SetRate(rates["read"], rates["burst"])
+	t.write.SetRate(rates["write"], rates["burst"])
+
+	return nil
+}
+
+// readLimiter returns the limiter for source reads, nil when t is nil
+func (t *throttle) readLimiter() *xbstream.RateLimiter {
+	if t == nil {
+		return nil
+	}
+	return t.read
+}
+
+// writeLimiter returns the limiter for writes, nil when t is nil
+func (t *throttle) writeLimiter() *xbstream.RateLimiter {
+	if t == nil {
+		return nil
+	}
+	return t.write
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// Exit codes reported by verify for each class of problem. When an archive has problems of several classes the
+// lowest of their codes is used.
+var verifyExitCodes = map[xbstream.ProblemClass]int{
+	xbstream.ProblemTruncated: 10,
+	xbstream.ProblemCorrupt:   11,
+	xbstream.ProblemChecksum:  12,
+	xbstream.ProblemLayout:    13,
+	xbstream.ProblemEOF:       14,
+}
+
+// verifyStream reads the archive end-to-end and reports its structural problems without writing any files
+func verifyStream(file *os.File, quiet bool) {
+	if *file == (os.File{}) {
+		file = os.Stdin
+	}
+
+	problems, err := xbstream.Verify(openStream(file))
+	if err != nil {
+		fatal(err)
+	}
+
+	code := 0
+	for _, problem := range problems {
+		if !quiet {
+			fmt.Println(problem)
+		}
+		if c := verifyExitCodes[problem.Class]; code == 0 || c < code {
+			code = c
+		}
+	}
+
+	if !quiet {
+		fmt.Printf("%d problems found\n", len(problems))
+	}
+
+	os.Exit(code)
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/skmcgrail/go-xbstream/xbstream"
+)
+
+// openStream returns a Reader for the archive in file. When file is the first volume of a multi-volume
+// archive, the following volumes are opened by name from the same directory as each is reached.
+func openStream(file *os.File) *xbstream.Reader {
+	header := make([]byte, xbstream.VolumeMarkerSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		fatal(err)
+	}
+	header = header[:n]
+
+	// Rewind when possible so the Reader can still seek over unread payloads
+	var input io.Reader = file
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		input = io.MultiReader(bytes.NewReader(header), file)
+	}
+
+	if !xbstream.IsVolume(header) {
+		return xbstream.NewReader(input)
+	}
+
+	base := strings.TrimSuffix(file.Name(), xbstream.VolumeName("", 1))
+	numbered := base != file.Name()
+
+	return xbstream.NewReader(xbstream.NewVolumeReader(func(volume int) (io.ReadCloser, error) {
+		if volume == 1 {
+			return ioutil.NopCloser(input), nil
+		}
+		if !numbered {
+			return nil, os.ErrNotExist
+		}
+		return os.Open(xbstream.VolumeName(base, volume))
+	}))
+}
+
+// parseSize parses a byte count with an optional K, M, G or T binary suffix
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+
+	digits, multiplier := s, int64(1)
+	switch suffix := strings.ToUpper(s[len(s)-1:]); suffix {
+	case "K", "M", "G", "T":
+		multiplier = 1 << (10 * uint(strings.Index("KMGT", suffix)+1))
+		digits = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// isVolumeSet reports whether the seekable file starts a volume set, rewinding it afterwards. Files that can not
+// be rewound are reported as not being a volume set.
+func isVolumeSet(file *os.File) bool {
+	if _, err := file.Seek(0, io.SeekCurrent); err != nil {
+		return false
+	}
+
+	header := make([]byte, xbstream.VolumeMarkerSize)
+	n, _ := io.ReadFull(file, header)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		fatal(err)
+	}
+	return xbstream.IsVolume(header[:n])
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// CatOptions controls how Cat holds chunks that arrive ahead of their position in the file
+type CatOptions struct {
+	SpillDir     string // directory for the spill file, the system temporary directory when empty
+	ReorderLimit int64  // bytes of out of order chunks held in memory before spilling, defaults to MinimumChunkSize
+}
+
+// Cat reads the archive from r and writes the content of the file stored as path to w, stopping once the
+// EOF chunk of the file is read. Chunks are written as soon as every preceding byte of the file has been
+// written; chunks stored out of order are held in memory up to the reorder limit and in a spill file beyond it.
+func Cat(w io.Writer, r *Reader, path string, opts CatOptions) error {
+	if opts.ReorderLimit <= 0 {
+		opts.ReorderLimit = MinimumChunkSize
+	}
+
+	b := &reorderBuffer{writer: w, limit: opts.ReorderLimit, spill: &spillBuffer{dir: opts.SpillDir}}
+	defer b.spill.Close()
+
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("%s: %w", path, ErrNotFound)
+			}
+			return err
+		}
+
+		if chunk.Type == ChunkTypeUnknown || string(chunk.Path) != path {
+			continue
+		}
+
+		if chunk.Type == ChunkTypeEOF {
+			if len(b.ranges) > 0 {
+				return fmt.Errorf("%s: missing data at offset %d", path, b.next)
+			}
+			return nil
+		}
+
+		if err = b.add(chunk); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+}
+
+// reorderBuffer writes chunk payloads to writer in file order
+type reorderBuffer struct {
+	writer io.Writer
+	next   int64 // file offset of the next byte to write
+	limit  int64
+	held   int64        // bytes of ranges held in memory
+	ranges []*heldRange // ranges waiting for the bytes before them, ordered by offset
+	spill  *spillBuffer // holds ranges beyond the memory limit at their file offset
+}
+
+type heldRange struct {
+	offset int64
+	length int64
+	data   []byte // nil when the range is stored in the spill file
+}
+
+// add verifies the payload of chunk before any of it is written, so a corrupt chunk never reaches the writer
+func (b *reorderBuffer) add(chunk *Chunk) error {
+	payload, err := readPayload(chunk)
+	if err != nil {
+		return err
+	}
+
+	if err = b.insert(bytes.NewReader(payload), int64(chunk.PayOffset), int64(len(payload))); err != nil {
+		return err
+	}
+
+	return b.drain()
+}
+
+// insert writes the length bytes read from r when they continue the bytes already written and holds them
+// otherwise, drain must be called afterwards to write the held ranges that have become contiguous
+func (b *reorderBuffer) insert(r io.Reader, offset, length int64) error {
+	if offset < b.next {
+		return fmt.Errorf("chunk at offset %d overlaps data already written", offset)
+	}
+
+	if offset == b.next {
+		if _, err := io.CopyN(b.writer, r, length); err != nil {
+			return err
+		}
+		b.next += length
+		return nil
+	}
+
+	held := &heldRange{offset: offset, length: length}
+	if b.held+length <= b.limit {
+		held.data = make([]byte, length)
+		if _, err := io.ReadFull(r, held.data); err != nil {
+			return err
+		}
+		b.held += length
+	} else if err := b.spill.copyAt(r, offset, length); err != nil {
+		return err
+	}
+
+	i := sort.Search(len(b.ranges), func(i int) bool { return b.ranges[i].offset > offset })
+	b.ranges = append(b.ranges, nil)
+	copy(b.ranges[i+1:], b.ranges[i:])
+	b.ranges[i] = held
+
+	return nil
+}
+
+// drain writes the held ranges that have become contiguous with the bytes already written
+func (b *reorderBuffer) drain() error {
+	for len(b.ranges) > 0 && b.ranges[0].offset <= b.next {
+		held := b.ranges[0]
+		if held.offset < b.next {
+			return fmt.Errorf("chunk at offset %d overlaps data already written", held.offset)
+		}
+
+		var src io.Reader
+		if held.data != nil {
+			src = bytes.NewReader(held.data)
+			b.held -= held.length
+		} else {
+			src = b.spill.section(held.offset, held.length)
+		}
+
+		if _, err := io.Copy(b.writer, src); err != nil {
+			return err
+		}
+		b.next += held.length
+		b.ranges = b.ranges[1:]
+	}
+	return nil
+}
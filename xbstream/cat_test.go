@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCat(t *testing.T) {
+	spill, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(spill)
+
+	var parts [][]byte
+	for i := 0; i < 4; i++ {
+		parts = append(parts, bytes.Repeat([]byte{byte(i)}, 100+i))
+	}
+	content := bytes.Join(parts, nil)
+	archive := buildArchive(t, testFile{"db/t1.ibd", parts}, testFile{"other", [][]byte{[]byte("other")}})
+
+	// Chunks of db/t1.ibd in the order 3, 1, 0, 2 so both memory and spill file hold ranges
+	chunks := splitChunks(t, archive)
+	reordered := bytes.Join([][]byte{chunks[3], chunks[1], chunks[0], chunks[2], chunks[4], chunks[5], chunks[6]}, nil)
+
+	out := new(bytes.Buffer)
+	opts := CatOptions{SpillDir: spill, ReorderLimit: 150}
+	require.NoError(t, Cat(out, NewReader(bytes.NewReader(reordered)), "db/t1.ibd", opts))
+	assert.Equal(t, content, out.Bytes())
+
+	out.Reset()
+	require.NoError(t, Cat(out, NewReader(bytes.NewReader(reordered)), "other", opts))
+	assert.Equal(t, "other", out.String())
+
+	err = Cat(ioutil.Discard, NewReader(bytes.NewReader(reordered)), "missing", opts)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	gap := bytes.Join([][]byte{chunks[0], chunks[2], chunks[3], chunks[4]}, nil)
+	assert.Error(t, Cat(ioutil.Discard, NewReader(bytes.NewReader(gap)), "db/t1.ibd", opts))
+
+	// A corrupt chunk that continues the written bytes is reported before any of it is written
+	corrupt := bytes.Join(chunks, nil)
+	corrupt[len(chunks[0])-1] ^= 0xff
+	out.Reset()
+	err = Cat(out, NewReader(bytes.NewReader(corrupt)), "db/t1.ibd", opts)
+	assert.True(t, errors.Is(err, ErrChecksum), "%v", err)
+	assert.Zero(t, out.Len())
+}
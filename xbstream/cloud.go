@@ -0,0 +1,275 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrBackupNotFound indicates an object store holds no objects for the requested backup
+var ErrBackupNotFound = errors.New("backup not found")
+
+// objectSequenceDigits is the width of the chunk sequence number ending each object name
+const objectSequenceDigits = 20
+
+// objectName returns the name of the seq'th chunk of the file stored as path, following xbcloud's layout
+func objectName(backup, path string, seq int) string {
+	return fmt.Sprintf("%s/%s.%0*d", backup, path, objectSequenceDigits, seq)
+}
+
+// parseObjectName splits an object name of backup into the path of its file and its chunk sequence number
+func parseObjectName(backup, name string) (string, int, bool) {
+	rest := strings.TrimPrefix(name, backup+"/")
+	i := strings.LastIndexByte(rest, '.')
+	if rest == name || i <= 0 || len(rest)-i-1 != objectSequenceDigits {
+		return "", 0, false
+	}
+
+	seq, err := strconv.Atoi(rest[i+1:])
+	if err != nil || seq < 0 {
+		return "", 0, false
+	}
+	return rest[:i], seq, true
+}
+
+func checkBackupName(backup string) error {
+	if backup == "" || backup == "." || backup == ".." || strings.ContainsAny(backup, "/\\") {
+		return fmt.Errorf("%q: a backup name must be a single non-empty path element", backup)
+	}
+	return nil
+}
+
+// checkObjectPath refuses chunk paths that would place objects outside their backup, or under another name
+func checkObjectPath(p string) error {
+	if p == "" || path.IsAbs(p) || path.Clean(p) != p || p == ".." || strings.HasPrefix(p, "../") {
+		return fmt.Errorf("%q: path can not be stored as an object name", p)
+	}
+	return nil
+}
+
+// Upload stores the archive read from r in store as backup, using the layout of xbcloud: every chunk is stored
+// on its own as the object <backup>/<path>.<seq>, where seq numbers the chunks of each file from zero with 20
+// digits and the EOF chunk of the file is its last object. Payload checksums are verified before upload. A
+// backup that already has objects in store is refused, as are paths that are absolute, not in their clean form
+// or that reach outside the backup.
+func Upload(store ObjectStore, backup string, r *Reader) error {
+	if err := checkBackupName(backup); err != nil {
+		return err
+	}
+
+	existing, err := store.List(backup + "/")
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("%s: backup already exists", backup)
+	}
+
+	sequences := make(map[string]int)
+
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if chunk.Type == ChunkTypeUnknown {
+			continue
+		}
+
+		path := string(chunk.Path)
+		if err = checkObjectPath(path); err != nil {
+			return err
+		}
+
+		header, err := encodeChunkHeader(chunk)
+		if err != nil {
+			return err
+		}
+
+		if chunk.Type == ChunkTypePayload {
+			// The length is checked before the payload is allocated
+			payload, err := readPayload(chunk)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			header.Write(payload)
+		}
+
+		seq := sequences[path]
+		sequences[path] = seq + 1
+
+		if err = store.Put(objectName(backup, path, seq), header); err != nil {
+			return err
+		}
+	}
+}
+
+// Download reads the objects of backup from store, as stored by Upload or xbcloud, and writes them to w as an
+// archive. Files are written one after another in path order, each from its objects in sequence order. Every
+// object must hold a single chunk of the file its name refers to, and the sequence of each file must be
+// complete. The error wraps ErrBackupNotFound when store holds no objects for backup.
+func Download(w *Writer, store ObjectStore, backup string) error {
+	if err := checkBackupName(backup); err != nil {
+		return err
+	}
+
+	names, err := store.List(backup + "/")
+	if err != nil {
+		return err
+	}
+
+	type object struct {
+		name string
+		seq  int
+	}
+	files := make(map[string][]object)
+	var paths []string
+	for _, name := range names {
+		path, seq, ok := parseObjectName(backup, name)
+		if !ok {
+			continue
+		}
+		if _, seen := files[path]; !seen {
+			paths = append(paths, path)
+		}
+		files[path] = append(files[path], object{name, seq})
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf("%s: %w", backup, ErrBackupNotFound)
+	}
+
+	sort.Strings(paths)
+	for _, path := range paths {
+		objects := files[path]
+		sort.Slice(objects, func(i, j int) bool { return objects[i].seq < objects[j].seq })
+
+		for i, obj := range objects {
+			if obj.seq != i {
+				return fmt.Errorf("%s: missing object %s", backup, objectName(backup, path, i))
+			}
+			last := i == len(objects)-1
+			if err = downloadObject(w, store, obj.name, path, last); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// downloadObject copies the single chunk stored as name to w once its checksum has been verified. The last
+// object of a file must hold its EOF chunk and no other may.
+func downloadObject(w *Writer, store ObjectStore, name, path string, last bool) error {
+	rc, err := store.Get(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	r := NewReader(rc)
+	chunk, err := r.Next()
+	if err != nil {
+		if err == io.EOF {
+			err = ErrTruncated
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if string(chunk.Path) != path {
+		return fmt.Errorf("%s: object holds a chunk of %s", name, chunk.Path)
+	}
+
+	switch {
+	case chunk.Type == ChunkTypeEOF && !last:
+		return fmt.Errorf("%s: EOF chunk is followed by further objects", name)
+	case chunk.Type != ChunkTypeEOF && last:
+		return fmt.Errorf("%s: missing EOF chunk, the last object is %s", path, name)
+	}
+
+	if chunk.Type == ChunkTypePayload {
+		payload, err := readPayload(chunk)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		chunk.Reader = bytes.NewReader(payload)
+	}
+
+	if _, err = r.Next(); err != io.EOF {
+		if err == nil {
+			err = errors.New("object holds more than one chunk")
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	return w.WriteChunk(chunk)
+}
+
+// DeleteBackup removes every object of backup from store
+func DeleteBackup(store ObjectStore, backup string) error {
+	if err := checkBackupName(backup); err != nil {
+		return err
+	}
+
+	names, err := store.List(backup + "/")
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("%s: %w", backup, ErrBackupNotFound)
+	}
+
+	for _, name := range names {
+		if err = store.Delete(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListBackups returns the names of the backups with objects in store
+func ListBackups(store ObjectStore) ([]string, error) {
+	names, err := store.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, name := range names {
+		i := strings.IndexByte(name, '/')
+		if i <= 0 {
+			continue
+		}
+		if backup := name[:i]; len(backups) == 0 || backups[len(backups)-1] != backup {
+			backups = append(backups, backup)
+		}
+	}
+	return backups, nil
+}
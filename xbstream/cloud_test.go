@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectStoreBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	block := func(b byte) []byte { return bytes.Repeat([]byte{b}, 100) }
+	archive := buildArchive(t, testFile{"ibdata1", [][]byte{block(1), block(2)}}, testFile{"db/t1.ibd", [][]byte{block(3)}})
+	store := NewDirStore(dir)
+
+	require.NoError(t, Upload(store, "full", NewReader(bytes.NewReader(archive))))
+	assert.Error(t, Upload(store, "full", NewReader(bytes.NewReader(archive))))
+
+	names, err := store.List("full/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"full/db/t1.ibd.00000000000000000000",
+		"full/db/t1.ibd.00000000000000000001",
+		"full/ibdata1.00000000000000000000",
+		"full/ibdata1.00000000000000000001",
+		"full/ibdata1.00000000000000000002",
+	}, names)
+
+	// Each object holds a single chunk, the last object of a file its EOF chunk
+	rc, err := store.Get("full/ibdata1.00000000000000000002")
+	require.NoError(t, err)
+	r := NewReader(rc)
+	chunk, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, ChunkTypeEOF, chunk.Type)
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+	rc.Close()
+
+	out := nopWriteCloser{new(bytes.Buffer)}
+	require.NoError(t, Download(NewWriter(out), store, "full"))
+	sink := NewMemorySink()
+	require.NoError(t, (&Extractor{Sink: sink}).Extract(NewReader(bytes.NewReader(out.Bytes()))))
+	assert.Equal(t, map[string][]byte{"ibdata1": append(block(1), block(2)...), "db/t1.ibd": block(3)}, sink.Files())
+
+	backups, err := ListBackups(store)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"full"}, backups)
+
+	download := func() error { return Download(NewWriter(nopWriteCloser{new(bytes.Buffer)}), store, "full") }
+
+	// A corrupt payload and a file whose final objects are missing are both reported
+	object, err := ioutil.ReadFile(dir + "/full/ibdata1.00000000000000000001")
+	require.NoError(t, err)
+	object[len(object)-1] ^= 0xff
+	require.NoError(t, store.Put("full/ibdata1.00000000000000000001", bytes.NewReader(object)))
+	assert.True(t, errors.Is(download(), ErrChecksum))
+	object[len(object)-1] ^= 0xff
+	require.NoError(t, store.Put("full/ibdata1.00000000000000000001", bytes.NewReader(object)))
+	require.NoError(t, download())
+
+	require.NoError(t, store.Delete("full/ibdata1.00000000000000000002"))
+	assert.Error(t, download())
+	require.NoError(t, store.Delete("full/ibdata1.00000000000000000001"))
+	assert.Error(t, download())
+
+	require.NoError(t, DeleteBackup(store, "full"))
+	assert.True(t, errors.Is(DeleteBackup(store, "full"), ErrBackupNotFound))
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	err = Download(NewWriter(nopWriteCloser{new(bytes.Buffer)}), store, "full")
+	assert.True(t, errors.Is(err, ErrBackupNotFound))
+	assert.Error(t, Upload(store, "../escape", NewReader(bytes.NewReader(archive))))
+
+	// Chunk paths can not place objects in another backup
+	for _, p := range []string{"../victim/ibdata1", "/abs", "a/../../b", "./a", ""} {
+		w := nopWriteCloser{new(bytes.Buffer)}
+		require.NoError(t, NewWriter(w).WriteChunk(&Chunk{ChunkHeader: ChunkHeader{Type: ChunkTypeEOF, Path: []byte(p)}}))
+		assert.Error(t, Upload(store, "b1", NewReader(bytes.NewReader(w.Bytes()))), p)
+	}
+
+	// A corrupt payload length is refused before the payload is allocated
+	huge := append([]byte(nil), archive...)
+	binary.LittleEndian.PutUint64(huge[14+len("ibdata1"):], 1<<62)
+	err = Upload(store, "b1", NewReader(bytes.NewReader(huge)))
+	assert.True(t, errors.Is(err, ErrPayloadLength), "%v", err)
+
+	backups, err = ListBackups(store)
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+}
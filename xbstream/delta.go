@@ -0,0 +1,456 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DeltaSuffix is appended to the tablespace path for incremental page deltas
+	DeltaSuffix = ".delta"
+	// DeltaMetaSuffix is appended to the tablespace path for the delta metadata
+	DeltaMetaSuffix = ".meta"
+)
+
+var (
+	deltaMagicLast = []byte("XTRA") // header of the final block of a delta
+	deltaMagic     = []byte("xtra") // header of every other block of a delta
+)
+
+// DeltaMeta contains the tablespace information recorded by xtrabackup alongside each .delta file
+type DeltaMeta struct {
+	PageSize   uint32
+	ZipSize    uint32
+	SpaceID    uint32
+	SpaceFlags uint32
+}
+
+// ParseDeltaMeta parses the contents of a .meta file
+func ParseDeltaMeta(r io.Reader) (*DeltaMeta, error) {
+	values, err := parseKeyValues(r)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := new(DeltaMeta)
+	for key, value := range values {
+		var field *uint32
+		switch key {
+		case "page_size":
+			field = &meta.PageSize
+		case "zip_size":
+			field = &meta.ZipSize
+		case "space_id":
+			field = &meta.SpaceID
+		case "space_flags":
+			field = &meta.SpaceFlags
+		default:
+			continue
+		}
+
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed delta meta value %s = %q", key, value)
+		}
+		*field = uint32(n)
+	}
+
+	if meta.pageSize() < 1024 || meta.pageSize()&(meta.pageSize()-1) != 0 {
+		return nil, fmt.Errorf("invalid delta page size %d", meta.pageSize())
+	}
+
+	return meta, nil
+}
+
+// pageSize returns the size of each page stored in the delta, compressed tablespaces store zip_size pages
+func (m *DeltaMeta) pageSize() int {
+	if m.ZipSize != 0 {
+		return int(m.ZipSize)
+	}
+	return int(m.PageSize)
+}
+
+// ApplyDelta reads an incremental delta from r and writes each page it contains to dst at page_no * page_size
+func ApplyDelta(dst io.WriterAt, r io.Reader, meta *DeltaMeta) error {
+	w := newDeltaWriter(dst, meta)
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// deltaWriter incrementally decodes a delta stream. A delta is a sequence of blocks, each starting with a
+// header page holding a magic followed by big-endian page numbers, terminated by FIL_NULL when the block is short.
+// The pages listed in the header follow it in order.
+type deltaWriter struct {
+	dst      io.WriterAt
+	pageSize int
+	buf      []byte
+	n        int      // bytes buffered in buf
+	pages    []uint32 // page numbers of the current block
+	next     int      // index into pages of the page being read, -1 while reading a header
+	last     bool     // current block is the final block
+	done     bool
+}
+
+func newDeltaWriter(dst io.WriterAt, meta *DeltaMeta) *deltaWriter {
+	return &deltaWriter{
+		dst:      dst,
+		pageSize: meta.pageSize(),
+		buf:      make([]byte, meta.pageSize()),
+		next:     -1,
+	}
+}
+
+func (w *deltaWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		if w.done {
+			return written, errors.New("data found after final delta block")
+		}
+
+		n := copy(w.buf[w.n:], p)
+		w.n += n
+		written += n
+		p = p[n:]
+
+		if w.n < w.pageSize {
+			break
+		}
+		w.n = 0
+
+		if w.next < 0 {
+			if err := w.readHeader(); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		if _, err := w.dst.WriteAt(w.buf, int64(w.pages[w.next])*int64(w.pageSize)); err != nil {
+			return written, err
+		}
+		w.next++
+		w.endOfBlock()
+	}
+
+	return written, nil
+}
+
+func (w *deltaWriter) readHeader() error {
+	switch {
+	case bytes.Equal(w.buf[:4], deltaMagicLast):
+		w.last = true
+	case bytes.Equal(w.buf[:4], deltaMagic):
+		w.last = false
+	default:
+		return errors.New("wrong delta block magic")
+	}
+
+	w.pages = w.pages[:0]
+	for i := 4; i < w.pageSize; i += 4 {
+		pageNo := binary.BigEndian.Uint32(w.buf[i:])
+		if pageNo == filNull {
+			break
+		}
+		w.pages = append(w.pages, pageNo)
+	}
+
+	if !w.last && len(w.pages) != w.pageSize/4-1 {
+		return errors.New("short delta block is not marked as final")
+	}
+
+	w.next = 0
+	w.endOfBlock()
+
+	return nil
+}
+
+// endOfBlock advances to the next block header once all pages of the current block have been written
+func (w *deltaWriter) endOfBlock() {
+	if w.next < len(w.pages) {
+		return
+	}
+	w.next = -1
+	w.done = w.last
+}
+
+// Close reports whether the delta ended cleanly after its final block
+func (w *deltaWriter) Close() error {
+	if !w.done || w.n != 0 {
+		return errors.New("delta is truncated")
+	}
+	return nil
+}
+
+// ApplyDeltas reads an incremental archive from r and applies every .delta member onto the tablespaces found
+// under dir. Tablespaces are matched by space id: a tablespace whose file was renamed since the base backup
+// is moved to its new path, and a delta for an unknown space id creates a new tablespace file.
+// Members other than deltas and their metadata are ignored. Payloads are verified against their checksums and
+// must be stored in file order, and compressed or encrypted deltas are refused since they can not be applied as is.
+func ApplyDeltas(r *Reader, dir string) error {
+	spaces, err := scanTablespaces(dir)
+	if err != nil {
+		return err
+	}
+
+	metas := make(map[string]*DeltaMeta)
+	metaBuffers := make(map[string]*bytes.Buffer)
+	pending := make(map[string]*pendingDelta) // delta payload received before its meta
+	active := make(map[string]*activeDelta)
+	offsets := make(map[string]uint64) // bytes of each delta and meta read so far
+
+	defer func() {
+		for _, d := range active {
+			d.file.Close()
+		}
+	}()
+
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		if chunk.Type == ChunkTypeUnknown {
+			continue
+		}
+
+		path := string(chunk.Path)
+		if !strings.HasSuffix(path, DeltaMetaSuffix) && !strings.HasSuffix(path, DeltaSuffix) {
+			if isTransformedDelta(path) {
+				return fmt.Errorf("%s: compressed or encrypted deltas must be decompressed and decrypted before they are applied", path)
+			}
+			continue
+		}
+
+		var payload []byte
+		if chunk.Type == ChunkTypePayload {
+			// Deltas are decoded as a stream, so a chunk out of order would write the wrong pages
+			if chunk.PayOffset != offsets[path] {
+				return fmt.Errorf("%s: chunk at offset %d does not follow the %d bytes already read", path, chunk.PayOffset, offsets[path])
+			}
+			if payload, err = readPayload(chunk); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			offsets[path] += chunk.PayLen
+		}
+
+		switch {
+		case strings.HasSuffix(path, DeltaMetaSuffix):
+			if chunk.Type != ChunkTypeEOF {
+				if metaBuffers[path] == nil {
+					metaBuffers[path] = new(bytes.Buffer)
+				}
+				metaBuffers[path].Write(payload)
+				continue
+			}
+
+			buffer, ok := metaBuffers[path]
+			if !ok {
+				buffer = new(bytes.Buffer)
+			}
+			delete(metaBuffers, path)
+
+			meta, err := ParseDeltaMeta(buffer)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+
+			name := strings.TrimSuffix(path, DeltaMetaSuffix)
+			metas[name] = meta
+
+			p, ok := pending[name]
+			if !ok {
+				continue
+			}
+			delete(pending, name)
+
+			d, err := openDelta(dir, name, meta, spaces)
+			if err != nil {
+				return err
+			}
+			active[name] = d
+
+			if _, err = io.Copy(d.writer, p.buffer); err != nil {
+				return fmt.Errorf("%s: %v", name+DeltaSuffix, err)
+			}
+			if p.complete {
+				delete(active, name)
+				if err = d.Close(); err != nil {
+					return fmt.Errorf("%s: %v", name+DeltaSuffix, err)
+				}
+			}
+		case strings.HasSuffix(path, DeltaSuffix):
+			name := strings.TrimSuffix(path, DeltaSuffix)
+
+			d, ok := active[name]
+			if !ok {
+				meta, known := metas[name]
+				if !known {
+					p, ok := pending[name]
+					if !ok {
+						p = &pendingDelta{buffer: new(bytes.Buffer)}
+						pending[name] = p
+					}
+					if chunk.Type == ChunkTypeEOF {
+						p.complete = true
+						continue
+					}
+					p.buffer.Write(payload)
+					continue
+				}
+
+				if d, err = openDelta(dir, name, meta, spaces); err != nil {
+					return err
+				}
+				active[name] = d
+			}
+
+			if chunk.Type == ChunkTypeEOF {
+				delete(active, name)
+				if err = d.Close(); err != nil {
+					return fmt.Errorf("%s: %v", path, err)
+				}
+				continue
+			}
+
+			if _, err = d.writer.Write(payload); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+
+	for name := range pending {
+		return fmt.Errorf("%s: missing %s", name+DeltaSuffix, name+DeltaMetaSuffix)
+	}
+	for name := range active {
+		return fmt.Errorf("%s: delta is truncated", name+DeltaSuffix)
+	}
+
+	return nil
+}
+
+// isTransformedDelta reports whether path is a delta or its metadata with a compression or encryption suffix
+func isTransformedDelta(path string) bool {
+	name := strings.TrimSuffix(path, encryptedSuffix)
+	for _, suffix := range compressedSuffixes {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	return name != path && (strings.HasSuffix(name, DeltaSuffix) || strings.HasSuffix(name, DeltaMetaSuffix))
+}
+
+type pendingDelta struct {
+	buffer   *bytes.Buffer
+	complete bool // the EOF chunk of the delta has been read
+}
+
+type activeDelta struct {
+	file   *os.File
+	writer *deltaWriter
+}
+
+// Close verifies the delta was fully applied and closes the tablespace
+func (d *activeDelta) Close() error {
+	if err := d.writer.Close(); err != nil {
+		d.file.Close()
+		return err
+	}
+	return d.file.Close()
+}
+
+// openDelta opens the tablespace the delta for name applies to, renaming or creating it as required
+func openDelta(dir, name string, meta *DeltaMeta, spaces map[uint32]string) (*activeDelta, error) {
+	target := filepath.Join(dir, name)
+
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return nil, err
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if current, ok := spaces[meta.SpaceID]; ok && current != target {
+		// The tablespace was renamed after the base backup was taken
+		if err := os.Rename(current, target); err != nil {
+			return nil, err
+		}
+	} else if !ok {
+		// A file at the target path that belongs to another space id was dropped and re-created
+		flags |= os.O_TRUNC
+		for id, path := range spaces {
+			if path == target {
+				delete(spaces, id)
+			}
+		}
+	}
+	spaces[meta.SpaceID] = target
+
+	file, err := os.OpenFile(target, flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	return &activeDelta{file: file, writer: newDeltaWriter(file, meta)}, nil
+}
+
+// scanTablespaces maps the space id of every tablespace file under dir to its path
+func scanTablespaces(dir string) (map[uint32]string, error) {
+	spaces := make(map[uint32]string)
+	header := make([]byte, filPageData)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || !isTablespace(path) {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if _, err = io.ReadFull(file, header); err != nil {
+			// Empty or truncated files can not be matched by space id
+			return nil
+		}
+		spaces[pageSpaceID(header)] = path
+
+		return nil
+	})
+
+	return spaces, err
+}
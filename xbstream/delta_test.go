@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPageSize = 1024
+
+// buildDelta encodes pages into a single final delta block
+func buildDelta(pages map[uint32]byte) []byte {
+	header := make([]byte, testPageSize)
+	copy(header, deltaMagicLast)
+
+	delta := new(bytes.Buffer)
+	body := new(bytes.Buffer)
+	i := 4
+	for pageNo := uint32(0); pageNo < 16; pageNo++ {
+		fill, ok := pages[pageNo]
+		if !ok {
+			continue
+		}
+		binary.BigEndian.PutUint32(header[i:], pageNo)
+		i += 4
+		page := bytes.Repeat([]byte{fill}, testPageSize)
+		binary.BigEndian.PutUint32(page[filPageSpaceID:], 7)
+		body.Write(page)
+	}
+	binary.BigEndian.PutUint32(header[i:], filNull)
+
+	delta.Write(header)
+	delta.Write(body.Bytes())
+
+	return delta.Bytes()
+}
+
+func TestParseDeltaMeta(t *testing.T) {
+	meta, err := ParseDeltaMeta(strings.NewReader("page_size = 16384\nzip_size = 8192\nspace_id = 42\nspace_flags = 33\n"))
+	require.NoError(t, err)
+	assert.Equal(t, &DeltaMeta{PageSize: 16384, ZipSize: 8192, SpaceID: 42, SpaceFlags: 33}, meta)
+	assert.Equal(t, 8192, meta.pageSize())
+
+	_, err = ParseDeltaMeta(strings.NewReader("page_size = 1000\n"))
+	assert.Error(t, err)
+}
+
+func TestApplyDelta(t *testing.T) {
+	file, err := ioutil.TempFile("", "delta")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	meta := &DeltaMeta{PageSize: testPageSize, SpaceID: 7}
+	require.NoError(t, ApplyDelta(file, bytes.NewReader(buildDelta(map[uint32]byte{1: 0xaa, 3: 0xbb})), meta))
+
+	contents, err := ioutil.ReadFile(file.Name())
+	require.NoError(t, err)
+	require.Len(t, contents, 4*testPageSize)
+	assert.Equal(t, byte(0), contents[filPageData])
+	assert.Equal(t, byte(0xaa), contents[testPageSize+filPageData])
+	assert.Equal(t, byte(0), contents[2*testPageSize+filPageData])
+	assert.Equal(t, byte(0xbb), contents[3*testPageSize+filPageData])
+
+	delta := buildDelta(map[uint32]byte{1: 0xaa})
+	assert.Error(t, ApplyDelta(file, bytes.NewReader(delta[:len(delta)-1]), meta), "truncated delta must fail")
+}
+
+func TestApplyDeltas(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deltas")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Base tablespace with space id 7 that has been renamed to db/t2.ibd in the incremental
+	base := bytes.Repeat([]byte{0x11}, 2*testPageSize)
+	binary.BigEndian.PutUint32(base[filPageSpaceID:], 7)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "db"), 0777))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "db", "t1.ibd"), base, 0666))
+
+	archive := buildArchive(t,
+		testFile{"db/t2.ibd.meta", [][]byte{[]byte("page_size = 1024\nzip_size = 0\nspace_id = 7\n")}},
+		testFile{"db/t2.ibd.delta", [][]byte{buildDelta(map[uint32]byte{1: 0x22})}},
+		testFile{"db/t3.ibd.delta", [][]byte{buildDelta(map[uint32]byte{0: 0x33})}},
+		testFile{"db/t3.ibd.meta", [][]byte{[]byte("page_size = 1024\nzip_size = 0\nspace_id = 9\n")}},
+		testFile{"db/t2.frm", [][]byte{[]byte("ignored")}},
+	)
+
+	require.NoError(t, ApplyDeltas(NewReader(bytes.NewReader(archive)), dir))
+
+	_, err = os.Stat(filepath.Join(dir, "db", "t1.ibd"))
+	assert.True(t, os.IsNotExist(err), "renamed tablespace should be moved")
+
+	t2, err := ioutil.ReadFile(filepath.Join(dir, "db", "t2.ibd"))
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x11), t2[filPageData])
+	assert.Equal(t, byte(0x22), t2[testPageSize+filPageData])
+
+	t3, err := ioutil.ReadFile(filepath.Join(dir, "db", "t3.ibd"))
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x33), t3[filPageData])
+
+	_, err = os.Stat(filepath.Join(dir, "db", "t2.frm"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Corrupt, out of order and compressed deltas are refused before any page is written
+	meta := []byte("page_size = 1024\nzip_size = 0\nspace_id = 7\n")
+	delta := buildDelta(map[uint32]byte{1: 0x44})
+	for name, chunks := range map[string][]*Chunk{
+		"checksum": {
+			{ChunkHeader: ChunkHeader{Type: ChunkTypePayload, Path: []byte("db/t2.ibd.meta"), PayLen: uint64(len(meta)), Checksum: crc32.ChecksumIEEE(meta)}, Reader: bytes.NewReader(meta)},
+			{ChunkHeader: ChunkHeader{Type: ChunkTypeEOF, Path: []byte("db/t2.ibd.meta")}},
+			{ChunkHeader: ChunkHeader{Type: ChunkTypePayload, Path: []byte("db/t2.ibd.delta"), PayLen: uint64(len(delta)), Checksum: 1}, Reader: bytes.NewReader(delta)},
+		},
+		"offset": {
+			{ChunkHeader: ChunkHeader{Type: ChunkTypePayload, Path: []byte("db/t2.ibd.delta"), PayLen: uint64(len(delta)), PayOffset: 1, Checksum: crc32.ChecksumIEEE(delta)}, Reader: bytes.NewReader(delta)},
+		},
+		"compressed": {
+			{ChunkHeader: ChunkHeader{Type: ChunkTypeEOF, Path: []byte("db/t2.ibd.delta.qp")}},
+		},
+	} {
+		archive := nopWriteCloser{new(bytes.Buffer)}
+		w := NewWriter(archive)
+		for _, chunk := range chunks {
+			require.NoError(t, w.WriteChunk(chunk))
+		}
+		assert.Error(t, ApplyDeltas(NewReader(bytes.NewReader(archive.Bytes())), dir), name)
+	}
+
+	t2, err = ioutil.ReadFile(filepath.Join(dir, "db", "t2.ibd"))
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x22), t2[testPageSize+filPageData])
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"io"
+	"sort"
+)
+
+// DiffKind categorises how a file differs between two archives
+type DiffKind int
+
+const (
+	// DiffAdded indicates a file only present in the second archive
+	DiffAdded DiffKind = iota + 1
+	// DiffRemoved indicates a file only present in the first archive
+	DiffRemoved
+	// DiffChanged indicates a file present in both archives with a different size or content
+	DiffChanged
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the kind by name
+func (k DiffKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// ByteRange is a contiguous range of bytes within a file
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// FileDiff describes a file that differs between two archives
+type FileDiff struct {
+	Path   string
+	Kind   DiffKind
+	SizeA  int64
+	SizeB  int64
+	Ranges []ByteRange // regions of a changed file that differ, or may differ where the chunk layouts disagree
+}
+
+// chunkSpan is the range of a file covered by a single chunk and the CRC32 of its payload
+type chunkSpan struct {
+	offset   int64
+	length   int64
+	checksum uint32
+}
+
+type fingerprint struct {
+	size  int64
+	spans []chunkSpan
+}
+
+// Diff compares the archives read from a and b and returns the files that were added, removed or changed,
+// ordered by path. Files are compared by size and by the CRC32 recorded in each chunk header, so payloads are
+// never read. Regions covered by chunks that have the same range and checksum in both archives are considered
+// equal, any other region is reported as differing.
+func Diff(a, b *Reader) ([]*FileDiff, error) {
+	filesA, err := fingerprints(a)
+	if err != nil {
+		return nil, err
+	}
+	filesB, err := fingerprints(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []*FileDiff
+	for path, fa := range filesA {
+		fb, ok := filesB[path]
+		if !ok {
+			diffs = append(diffs, &FileDiff{Path: path, Kind: DiffRemoved, SizeA: fa.size})
+			continue
+		}
+
+		ranges := differingRanges(fa, fb)
+		if len(ranges) > 0 || fa.size != fb.size {
+			diffs = append(diffs, &FileDiff{Path: path, Kind: DiffChanged, SizeA: fa.size, SizeB: fb.size, Ranges: ranges})
+		}
+	}
+	for path, fb := range filesB {
+		if _, ok := filesA[path]; !ok {
+			diffs = append(diffs, &FileDiff{Path: path, Kind: DiffAdded, SizeB: fb.size})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+
+	return diffs, nil
+}
+
+// fingerprints reads the chunk headers of every file in the archive
+func fingerprints(r *Reader) (map[string]*fingerprint, error) {
+	files := make(map[string]*fingerprint)
+
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				return files, nil
+			}
+			return nil, err
+		}
+
+		if chunk.Type == ChunkTypeUnknown {
+			continue
+		}
+
+		path := string(chunk.Path)
+		f, ok := files[path]
+		if !ok {
+			f = new(fingerprint)
+			files[path] = f
+		}
+
+		if chunk.Type == ChunkTypeEOF {
+			continue
+		}
+
+		span := chunkSpan{offset: int64(chunk.PayOffset), length: int64(chunk.PayLen), checksum: chunk.Checksum}
+		f.spans = append(f.spans, span)
+		if end := span.offset + span.length; end > f.size {
+			f.size = end
+		}
+	}
+}
+
+// differingRanges returns the merged ranges of the chunks of a and b that have no identical chunk in the other
+func differingRanges(a, b *fingerprint) []ByteRange {
+	var unmatched []ByteRange
+	collect := func(spans, other []chunkSpan) {
+		identical := make(map[chunkSpan]bool, len(other))
+		for _, span := range other {
+			identical[span] = true
+		}
+		for _, span := range spans {
+			if !identical[span] && span.length > 0 {
+				unmatched = append(unmatched, ByteRange{Offset: span.offset, Length: span.length})
+			}
+		}
+	}
+	collect(a.spans, b.spans)
+	collect(b.spans, a.spans)
+
+	sort.Slice(unmatched, func(i, j int) bool { return unmatched[i].Offset < unmatched[j].Offset })
+
+	var ranges []ByteRange
+	for _, r := range unmatched {
+		if n := len(ranges); n > 0 && r.Offset <= ranges[n-1].Offset+ranges[n-1].Length {
+			if end := r.Offset + r.Length; end > ranges[n-1].Offset+ranges[n-1].Length {
+				ranges[n-1].Length = end - ranges[n-1].Offset
+			}
+			continue
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
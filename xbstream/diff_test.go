@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	block := func(b byte) []byte { return bytes.Repeat([]byte{b}, 100) }
+
+	a := buildArchive(t,
+		testFile{"same", [][]byte{block(1), block(2)}},
+		testFile{"removed", [][]byte{block(3)}},
+		testFile{"changed", [][]byte{block(4), block(5), block(6), block(7)}},
+		testFile{"grown", [][]byte{block(8)}},
+	)
+	b := buildArchive(t,
+		testFile{"same", [][]byte{block(1), block(2)}},
+		testFile{"added", [][]byte{block(3)}},
+		testFile{"changed", [][]byte{block(4), block(0), block(6), block(7)}},
+		testFile{"grown", [][]byte{block(8), block(9)}},
+	)
+
+	diffs, err := Diff(NewReader(bytes.NewReader(a)), NewReader(bytes.NewReader(b)))
+	require.NoError(t, err)
+
+	assert.Equal(t, []*FileDiff{
+		{Path: "added", Kind: DiffAdded, SizeB: 100},
+		{Path: "changed", Kind: DiffChanged, SizeA: 400, SizeB: 400, Ranges: []ByteRange{{Offset: 100, Length: 100}}},
+		{Path: "grown", Kind: DiffChanged, SizeA: 100, SizeB: 200, Ranges: []ByteRange{{Offset: 100, Length: 100}}},
+		{Path: "removed", Kind: DiffRemoved, SizeA: 100},
+	}, diffs)
+
+	// The same content split into different chunks can only be reported as possibly differing
+	c := buildArchive(t, testFile{"same", [][]byte{append(block(1), block(2)...)}})
+	d := buildArchive(t, testFile{"same", [][]byte{block(1), block(2)}})
+	diffs, err = Diff(NewReader(bytes.NewReader(c)), NewReader(bytes.NewReader(d)))
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	assert.Equal(t, []ByteRange{{Offset: 0, Length: 200}}, diffs[0].Ranges)
+}
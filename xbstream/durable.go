@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// stageDir creates an empty staging directory beside dir, on the same filesystem so it can be renamed to dir
+func stageDir(dir string) (string, error) {
+	dir = filepath.Clean(dir)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if len(entries) > 0 {
+		return "", fmt.Errorf("%s: durable extraction requires a directory that does not exist or is empty", dir)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(dir), 0777); err != nil {
+		return "", err
+	}
+
+	// ioutil.TempDir creates directories as 0700, the staging directory becomes dir so it is created like
+	// any other extracted directory
+	for i := 0; ; i++ {
+		staging := filepath.Join(filepath.Dir(dir), fmt.Sprintf(".%s.partial-%d-%d", filepath.Base(dir), os.Getpid(), i))
+		if err = os.Mkdir(staging, 0777); !os.IsExist(err) {
+			return staging, err
+		}
+	}
+}
+
+// commitDir syncs every directory beneath staging, renames it to dir and syncs the parent of dir so the
+// rename itself is durable. Files are expected to have been synced as they were completed.
+func commitDir(staging, dir string) error {
+	err := filepath.Walk(staging, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		return syncPath(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	// An empty dir is replaced by the rename on POSIX systems, elsewhere it has to be removed first
+	if err = os.Rename(staging, dir); err != nil {
+		if rerr := os.Remove(dir); rerr != nil {
+			return err
+		}
+		if err = os.Rename(staging, dir); err != nil {
+			return err
+		}
+	}
+
+	return syncPath(filepath.Dir(dir))
+}
+
+// syncPath flushes the file or directory at path to stable storage
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
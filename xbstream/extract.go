@@ -0,0 +1,401 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// Extractor writes the files stored in an archive beneath a directory, or to a Sink. Chunk headers are decoded on the calling
+// goroutine while checksum verification and positional writes of the payloads are handed to a pool of workers.
+// At most 2*Parallel payloads are held in memory at any time.
+type Extractor struct {
+	Dir      string                 // directory files are extracted beneath when Sink is not set
+	Sink     Sink                   // receives the extracted files instead of Dir when set
+	Parallel int                    // number of workers writing payloads, defaults to 1
+	Filter   func(path string) bool // when set only files for which Filter returns true are extracted
+
+	// VerifySkipped checksums the payloads of files rejected by Filter. By default their chunks are skipped
+	// without being read.
+	VerifySkipped bool
+
+	// Progress is called on the goroutine calling Extract as each chunk is read from the archive, including
+	// the chunks of files rejected by Filter
+	Progress func(Progress)
+
+	// Limiter, when set, limits the rate at which payloads are written to the extracted files
+	Limiter *RateLimiter
+
+	// Durable extracts into a staging directory beside Dir, syncs every file and directory and then renames
+	// the staging directory to Dir, which must not exist or be empty. On failure the staging directory is
+	// removed, so Dir is either complete or untouched. Files without an EOF chunk fail the extraction.
+	// Durable can not be combined with Sink.
+	Durable bool
+
+	// Journal, when set, is the path of a journal recording the files and chunks extracted and synced so far.
+	// Extracting the same archive again with the journal skips the files it records as complete, reading
+	// neither their chunks nor their payloads, and the chunks it records as written, matched by path, offset,
+	// length and checksum. Journal can not be combined with Durable.
+	Journal string
+	// JournalInterval is the number of payload bytes extracted between syncs of the files being extracted
+	// and their journal records, defaults to DefaultJournalInterval
+	JournalInterval int64
+
+	sink    Sink // Sink, or a directory sink for Dir or the staging directory
+	journal *journal
+	buffers sync.Pool
+	mutex   sync.Mutex
+	err     error
+	ctx     context.Context // done once the extraction fails, ending waits on Limiter
+	cancel  context.CancelFunc
+}
+
+type extractFile struct {
+	path    string
+	file    SinkFile
+	pending sync.WaitGroup // payload writes queued or in progress
+	mutex   sync.Mutex
+	written []journalRange // payloads written since the last journal checkpoint
+}
+
+type extractJob struct {
+	path     string
+	file     *extractFile
+	offset   int64
+	payload  *[]byte
+	checksum uint32
+}
+
+// Extract reads the archive from r and writes every file it contains. Extraction stops at the first error.
+func (e *Extractor) Extract(r *Reader) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	e.mutex.Lock()
+	e.err = nil
+	e.ctx, e.cancel = ctx, cancel
+	e.mutex.Unlock()
+
+	if e.Journal != "" {
+		if e.Durable {
+			return errors.New("a journal can not be combined with durable extraction")
+		}
+
+		j, err := openJournal(e.Journal)
+		if err != nil {
+			return err
+		}
+		defer j.Close()
+		e.journal = j
+		defer func() { e.journal = nil }()
+	}
+
+	if !e.Durable {
+		e.sink = e.Sink
+		if e.sink == nil {
+			e.sink = NewDirSink(e.Dir)
+		}
+		return e.extract(r)
+	}
+
+	if e.Sink != nil {
+		return errors.New("durable extraction can not be combined with a sink")
+	}
+
+	staging, err := stageDir(e.Dir)
+	if err != nil {
+		return err
+	}
+	e.sink = NewDirSink(staging)
+
+	if err = e.extract(r); err == nil {
+		err = commitDir(staging, e.Dir)
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+	}
+	return err
+}
+
+// Cancel stops an extraction in progress, Extract returns once the chunk being read has been dispatched
+func (e *Extractor) Cancel() {
+	e.fail(ErrCanceled)
+}
+
+func (e *Extractor) extract(r *Reader) error {
+	parallel := e.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	jobs := make(chan *extractJob, parallel)
+	workers := sync.WaitGroup{}
+	closers := sync.WaitGroup{}
+
+	for i := 0; i < parallel; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				e.write(job)
+			}
+		}()
+	}
+
+	files := make(map[string]*extractFile)
+
+	err := e.decode(r, files, jobs, &closers)
+
+	close(jobs)
+	workers.Wait()
+
+	// Files without an EOF chunk are aborted before waiting on the closers of the others, whose Finalize may
+	// wait on resources the unfinished files hold, such as the command slots of a CommandSink
+	for path, f := range files {
+		if e.journal != nil {
+			// Acknowledge the payloads written so far so a later extraction can resume from them
+			if cerr := e.checkpoint(f); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		f.file.Abort()
+		if e.Durable && err == nil {
+			err = fmt.Errorf("%s: missing EOF chunk", path)
+		}
+	}
+
+	closers.Wait()
+
+	if err != nil {
+		return err
+	}
+	return e.failure()
+}
+
+// decode reads chunks from r and dispatches their payloads to the workers until the archive ends or fails
+func (e *Extractor) decode(r *Reader, files map[string]*extractFile, jobs chan<- *extractJob, closers *sync.WaitGroup) error {
+	progress := progressCounter{fn: e.Progress}
+
+	interval := e.JournalInterval
+	if interval <= 0 {
+		interval = DefaultJournalInterval
+	}
+	var sinceCheckpoint int64
+
+	finished := make(map[string]bool) // paths whose EOF chunk has been read
+
+	for e.failure() == nil {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if chunk.Type == ChunkTypeUnknown {
+			continue
+		}
+
+		progress.chunk(chunk)
+
+		path := string(chunk.Path)
+		if e.Filter != nil && !e.Filter(path) {
+			if e.VerifySkipped && chunk.Type == ChunkTypePayload {
+				hash := crc32.NewIEEE()
+				if _, err = io.Copy(hash, chunk); err != nil {
+					return err
+				}
+				if hash.Sum32() != chunk.Checksum {
+					return fmt.Errorf("%s: %w at offset %d", path, ErrChecksum, chunk.PayOffset)
+				}
+			}
+			continue
+		}
+
+		if e.journal != nil && (e.journal.completed(path) || chunk.Type == ChunkTypePayload && e.journal.applied(chunk)) {
+			continue
+		}
+
+		if finished[path] {
+			// Creating the file again would truncate the copy that has already been finalized
+			return fmt.Errorf("%s: chunk at stream offset %d follows the EOF chunk of the file", path, chunk.Offset)
+		}
+
+		f, ok := files[path]
+		if !ok {
+			if f, err = e.create(path); err != nil {
+				return err
+			}
+			files[path] = f
+		}
+
+		if chunk.Type == ChunkTypeEOF {
+			delete(files, path)
+			finished[path] = true
+			closers.Add(1)
+			go func() {
+				defer closers.Done()
+				f.pending.Wait()
+				if (e.Durable || e.journal != nil) && e.failure() == nil {
+					if err := syncFile(f.file); err != nil {
+						e.fail(err)
+					}
+				}
+				if e.failure() != nil {
+					f.file.Abort()
+				} else if err := f.file.Finalize(); err != nil {
+					e.fail(err)
+				}
+				if e.journal != nil && e.failure() == nil {
+					if err := e.journal.complete(f.path); err != nil {
+						e.fail(err)
+					}
+				}
+			}()
+			continue
+		}
+
+		payload := e.buffer(int(chunk.PayLen))
+		if _, err = io.ReadFull(chunk, *payload); err != nil {
+			return err
+		}
+
+		if e.journal != nil {
+			if sinceCheckpoint += int64(chunk.PayLen); sinceCheckpoint >= interval {
+				sinceCheckpoint = 0
+				for _, open := range files {
+					if err = e.checkpoint(open); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		f.pending.Add(1)
+		jobs <- &extractJob{
+			path:     path,
+			file:     f,
+			offset:   int64(chunk.PayOffset),
+			payload:  payload,
+			checksum: chunk.Checksum,
+		}
+	}
+
+	return nil
+}
+
+// create starts the file for path in the sink, keeping the content of a file partially extracted by a previous run
+func (e *Extractor) create(path string) (*extractFile, error) {
+	file, err := e.sink.Create(path, e.journal != nil && e.journal.started(path))
+	if err != nil {
+		return nil, err
+	}
+
+	return &extractFile{path: path, file: file}, nil
+}
+
+func (e *Extractor) write(job *extractJob) {
+	defer job.file.pending.Done()
+	defer e.buffers.Put(job.payload)
+
+	if e.failure() != nil {
+		return
+	}
+
+	if crc32.ChecksumIEEE(*job.payload) != job.checksum {
+		e.fail(fmt.Errorf("%s: %w at offset %d", job.path, ErrChecksum, job.offset))
+		return
+	}
+
+	if err := e.Limiter.Wait(e.ctx, len(*job.payload)); err != nil {
+		e.fail(err)
+		return
+	}
+
+	if _, err := job.file.file.WriteAt(*job.payload, job.offset); err != nil {
+		e.fail(err)
+		return
+	}
+
+	if e.journal != nil {
+		job.file.mutex.Lock()
+		job.file.written = append(job.file.written, journalRange{offset: job.offset, length: int64(len(*job.payload)), checksum: job.checksum})
+		job.file.mutex.Unlock()
+	}
+}
+
+// checkpoint syncs f and records the payloads written to it since the previous checkpoint in the journal.
+// Payloads still being written are recorded by a later checkpoint.
+func (e *Extractor) checkpoint(f *extractFile) error {
+	f.mutex.Lock()
+	written := f.written
+	f.written = nil
+	f.mutex.Unlock()
+
+	if len(written) == 0 {
+		return nil
+	}
+	if err := syncFile(f.file); err != nil {
+		return err
+	}
+	return e.journal.acknowledge(f.path, written)
+}
+
+// syncFile flushes f to stable storage when its sink supports it
+func syncFile(f SinkFile) error {
+	if s, ok := f.(syncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// buffer returns a pooled payload buffer of length n
+func (e *Extractor) buffer(n int) *[]byte {
+	if b, ok := e.buffers.Get().(*[]byte); ok && cap(*b) >= n {
+		*b = (*b)[:n]
+		return b
+	}
+	b := make([]byte, n)
+	return &b
+}
+
+func (e *Extractor) fail(err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.err == nil {
+		e.err = err
+		if e.cancel != nil {
+			e.cancel()
+		}
+	}
+}
+
+func (e *Extractor) failure() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.err
+}
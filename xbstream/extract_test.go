@@ -0,0 +1,203 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var files []testFile
+	for i := 0; i < 8; i++ {
+		files = append(files, testFile{fmt.Sprintf("db%d/t%d.ibd", i%2, i), [][]byte{bytes.Repeat([]byte{byte(i)}, 1000+i)}})
+	}
+
+	e := &Extractor{Dir: dir, Parallel: 4, Filter: func(path string) bool { return path != "db1/t7.ibd" }}
+	require.NoError(t, e.Extract(NewReader(bytes.NewReader(buildArchive(t, files...)))))
+
+	for _, file := range files {
+		extracted, err := ioutil.ReadFile(filepath.Join(dir, file.path))
+		if file.path == "db1/t7.ibd" {
+			assert.True(t, os.IsNotExist(err), "filtered file should not be extracted")
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, file.parts[0], extracted, file.path)
+	}
+}
+
+func TestExtractorErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	corrupt := append([]byte(nil), xbFile...)
+	corrupt[40] ^= 0xff
+	err = (&Extractor{Dir: dir}).Extract(NewReader(bytes.NewReader(corrupt)))
+	assert.True(t, errors.Is(err, ErrChecksum), "%v", err)
+
+	escape := buildArchive(t, testFile{"../escape", [][]byte{[]byte("x")}})
+	assert.Error(t, (&Extractor{Dir: dir}).Extract(NewReader(bytes.NewReader(escape))))
+
+	// A corrupt payload length is refused before any memory is allocated for it
+	huge := buildArchive(t, testFile{"a", [][]byte{[]byte("x")}})
+	binary.LittleEndian.PutUint64(huge[15:], 1<<62)
+	err = (&Extractor{Dir: dir}).Extract(NewReader(bytes.NewReader(huge)))
+	assert.True(t, errors.Is(err, ErrPayloadLength), "%v", err)
+
+	// A chunk after the EOF chunk of its file must not re-create the finished file
+	stray := buildArchive(t, testFile{"a", [][]byte{[]byte("one")}}, testFile{"a", [][]byte{[]byte("x")}})
+	assert.Error(t, (&Extractor{Dir: dir}).Extract(NewReader(bytes.NewReader(stray))))
+}
+
+func TestExtractorDurable(t *testing.T) {
+	parent, err := ioutil.TempDir("", "durable")
+	require.NoError(t, err)
+	defer os.RemoveAll(parent)
+
+	dir := filepath.Join(parent, "restore")
+	archive := buildArchive(t, testFile{"db/t1.ibd", [][]byte{[]byte("one")}}, testFile{"ibdata1", [][]byte{[]byte("two")}})
+
+	require.NoError(t, (&Extractor{Dir: dir, Durable: true}).Extract(NewReader(bytes.NewReader(archive))))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "db/t1.ibd"))
+	require.NoError(t, err)
+	assert.Equal(t, "one", string(data))
+
+	// A populated target is refused
+	assert.Error(t, (&Extractor{Dir: dir, Durable: true}).Extract(NewReader(bytes.NewReader(archive))))
+
+	// A failed extraction leaves neither the target nor the staging directory behind
+	failed := filepath.Join(parent, "failed")
+	err = (&Extractor{Dir: failed, Durable: true}).Extract(NewReader(bytes.NewReader(archive[:len(archive)-5])))
+	assert.Error(t, err)
+	entries, err := ioutil.ReadDir(parent)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "restore", entries[0].Name())
+}
+
+func TestExtractorJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	block := func(b byte) []byte { return bytes.Repeat([]byte{b}, 100) }
+	files := map[string][][]byte{
+		"a": {block(1), block(2)},
+		"b": {block(3), block(4), block(5)},
+	}
+	archive := buildArchive(t, testFile{"a", files["a"]}, testFile{"b", files["b"]})
+	path := filepath.Join(dir, "journal")
+	out := filepath.Join(dir, "out")
+	require.NoError(t, os.Mkdir(out, 0777))
+
+	// An interrupted extraction records the completed file and the payloads written to the other
+	err = (&Extractor{Dir: out, Journal: path, JournalInterval: 1}).Extract(NewReader(bytes.NewReader(archive[:len(archive)-5])))
+	assert.Error(t, err)
+
+	j, err := openJournal(path)
+	require.NoError(t, err)
+	var complete, started []string
+	for name := range files {
+		if j.completed(name) {
+			complete = append(complete, name)
+		} else if j.started(name) {
+			started = append(started, name)
+		}
+	}
+	require.NoError(t, j.Close())
+	require.Len(t, complete, 1)
+	require.Len(t, started, 1)
+
+	// Resuming skips the completed file, so content changed since is kept, and finishes the other
+	require.NoError(t, ioutil.WriteFile(filepath.Join(out, complete[0]), []byte("changed"), 0666))
+	require.NoError(t, (&Extractor{Dir: out, Journal: path}).Extract(NewReader(bytes.NewReader(archive))))
+
+	data, err := ioutil.ReadFile(filepath.Join(out, complete[0]))
+	require.NoError(t, err)
+	assert.Equal(t, "changed", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(out, started[0]))
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Join(files[started[0]], nil), data)
+
+	assert.Error(t, (&Extractor{Dir: out, Journal: path, Durable: true}).Extract(NewReader(bytes.NewReader(archive))))
+}
+
+func TestExtractorCancelLimited(t *testing.T) {
+	archive := buildArchive(t, testFile{"a", [][]byte{bytes.Repeat([]byte{1}, 1000)}})
+
+	// At one byte per second the payload would take minutes, canceling must end the wait
+	started := make(chan struct{})
+	var once sync.Once
+	e := &Extractor{Sink: Discard, Limiter: NewRateLimiter(1, 1), Progress: func(Progress) { once.Do(func() { close(started) }) }}
+	done := make(chan error, 1)
+	go func() { done <- e.Extract(NewReader(bytes.NewReader(archive))) }()
+	<-started
+	e.Cancel()
+
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, ErrCanceled), "%v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("extraction was not canceled")
+	}
+}
+
+func TestJournalTornRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The process died while appending the second record
+	path := filepath.Join(dir, "journal")
+	require.NoError(t, ioutil.WriteFile(path, []byte("file \"a\"\nrange 0 100 12"), 0666))
+
+	j, err := openJournal(path)
+	require.NoError(t, err)
+	assert.True(t, j.completed("a"))
+	require.NoError(t, j.complete("b"))
+	require.NoError(t, j.Close())
+
+	j, err = openJournal(path)
+	require.NoError(t, err)
+	assert.True(t, j.completed("a"))
+	assert.True(t, j.completed("b"))
+	require.NoError(t, j.Close())
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("bogus\nfile \"a\"\n"), 0666))
+	_, err = openJournal(path)
+	assert.Error(t, err)
+}
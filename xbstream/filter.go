@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// PathFilter selects archive members by path. Patterns are globs unless prefixed by '~', in which case the
+// remainder is a regular expression matched against the full path. A glob also matches every member beneath
+// a directory it matches, and a glob without a slash is matched against the base name of each member.
+type PathFilter struct {
+	include []pathPattern
+	exclude []pathPattern
+}
+
+type pathPattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// NewPathFilter creates a PathFilter matching members that match any include pattern, or every member when no
+// include patterns are given, and that match none of the exclude patterns
+func NewPathFilter(include, exclude []string) (*PathFilter, error) {
+	var err error
+	f := new(PathFilter)
+
+	if f.include, err = compilePathPatterns(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compilePathPatterns(exclude); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func compilePathPatterns(patterns []string) ([]pathPattern, error) {
+	var compiled []pathPattern
+
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+
+		if strings.HasPrefix(pattern, "~") {
+			re, err := regexp.Compile(pattern[1:])
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, pathPattern{re: re})
+			continue
+		}
+
+		pattern = strings.TrimSuffix(pattern, "/")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("path pattern %q: %v", pattern, err)
+		}
+		compiled = append(compiled, pathPattern{glob: pattern})
+	}
+
+	return compiled, nil
+}
+
+// Match reports whether the member at p is selected by the filter
+func (f *PathFilter) Match(p string) bool {
+	if len(f.include) > 0 && !matchAny(f.include, p) {
+		return false
+	}
+	return !matchAny(f.exclude, p)
+}
+
+func matchAny(patterns []pathPattern, p string) bool {
+	for _, pattern := range patterns {
+		if pattern.re != nil {
+			if pattern.re.MatchString(p) {
+				return true
+			}
+			continue
+		}
+
+		for name := p; name != "." && name != "/" && name != ""; name = path.Dir(name) {
+			if ok, _ := path.Match(pattern.glob, name); ok {
+				return true
+			}
+		}
+		if !strings.Contains(pattern.glob, "/") {
+			if ok, _ := path.Match(pattern.glob, path.Base(p)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
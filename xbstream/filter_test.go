@@ -0,0 +1,52 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathFilter(t *testing.T) {
+	filter, err := NewPathFilter([]string{"xtrabackup_*", "sales/", "~^hr/.*\\.ibd$"}, []string{"sales/tmp*"})
+	require.NoError(t, err)
+
+	for p, expected := range map[string]bool{
+		"xtrabackup_checkpoints": true,
+		"xtrabackup_logfile":     true,
+		"sales/orders.ibd":       true,
+		"sales/tmp1.ibd":         false,
+		"hr/people.ibd":          true,
+		"hr/people.frm":          false,
+		"ibdata1":                false,
+	} {
+		assert.Equal(t, expected, filter.Match(p), p)
+	}
+
+	excludeOnly, err := NewPathFilter(nil, []string{"*.ibd"})
+	require.NoError(t, err)
+	assert.True(t, excludeOnly.Match("ibdata1"))
+	assert.False(t, excludeOnly.Match("db/t1.ibd"), "globs match against every directory level")
+
+	_, err = NewPathFilter([]string{"["}, nil)
+	assert.Error(t, err)
+}
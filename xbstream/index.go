@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import "io"
+
+// IndexEntry summarises the chunks stored in an archive for a single file
+type IndexEntry struct {
+	Path        string
+	Size        int64 // reconstructed file size, the end of the furthest payload
+	Chunks      int   // number of payload chunks
+	FirstOffset int64 // stream offset of the first chunk of the file
+	LastOffset  int64 // stream offset of the last chunk of the file, including its EOF chunk
+	EOF         bool  // the EOF chunk of the file was found
+}
+
+// BuildIndex reads an archive from r and returns an entry for every file, ordered by first appearance
+func BuildIndex(r *Reader) ([]*IndexEntry, error) {
+	var entries []*IndexEntry
+	files := make(map[string]*IndexEntry)
+
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		if chunk.Type == ChunkTypeUnknown {
+			continue
+		}
+
+		path := string(chunk.Path)
+		entry, ok := files[path]
+		if !ok {
+			entry = &IndexEntry{Path: path, FirstOffset: chunk.Offset}
+			files[path] = entry
+			entries = append(entries, entry)
+		}
+		entry.LastOffset = chunk.Offset
+
+		if chunk.Type == ChunkTypeEOF {
+			entry.EOF = true
+			continue
+		}
+
+		entry.Chunks++
+		if end := int64(chunk.PayOffset + chunk.PayLen); end > entry.Size {
+			entry.Size = end
+		}
+	}
+
+	return entries, nil
+}
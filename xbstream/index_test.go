@@ -0,0 +1,43 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildIndex(t *testing.T) {
+	entries, err := BuildIndex(NewReader(bytes.NewReader(xbFile)))
+	require.NoError(t, err)
+
+	assert.Equal(t, []*IndexEntry{
+		{Path: "file1", Size: 5, Chunks: 1, FirstOffset: 0, LastOffset: 44, EOF: true},
+		{Path: "file2", Size: 5, Chunks: 1, FirstOffset: 63, LastOffset: 107, EOF: true},
+	}, entries)
+
+	entries, err = BuildIndex(NewReader(bytes.NewReader(xbFile[:63])))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, entries[0].EOF)
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	compressedSuffixes = []string{".qp", ".zst", ".lz4"}
+	encryptedSuffix    = ".xbcrypt"
+
+	// chunkSizeBounds are the upper bounds of the chunk size histogram buckets
+	chunkSizeBounds = []int64{1 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, MinimumChunkSize, 64 << 20}
+)
+
+// ArchiveInfo summarises the contents of an archive
+type ArchiveInfo struct {
+	Files        int
+	Chunks       int
+	LogicalBytes int64 // sum of the reconstructed file sizes
+	PayloadBytes int64 // sum of the chunk payloads, exceeds LogicalBytes when chunks overlap
+	StreamBytes  int64 // size of the archive including chunk headers
+	ChunkSizes   []*ChunkSizeBucket
+	Interleaving int         // largest number of files with chunks in flight at any point of the stream
+	Compressed   int         // files stored with a qpress, zstd or lz4 suffix
+	Encrypted    int         // files stored with the xbcrypt suffix
+	Backup       *BackupInfo // nil when the archive contains no xtrabackup metadata that could be parsed
+	Warnings     []string    // metadata files that could not be verified or parsed
+}
+
+// ChunkSizeBucket counts the payload chunks with a size in the range (Min, Max]. Max is zero for the last
+// bucket which has no upper bound.
+type ChunkSizeBucket struct {
+	Min   int64
+	Max   int64
+	Count int
+}
+
+// Summarize reads the archive from r and returns its summary, including the backup metadata recorded in
+// xtrabackup_checkpoints, xtrabackup_info and xtrabackup_binlog_info when they are present. Metadata chunks are
+// verified against their checksums and must be stored in file order, a metadata file that fails is reported in
+// Warnings instead of being parsed.
+func Summarize(r *Reader) (*ArchiveInfo, error) {
+	info := new(ArchiveInfo)
+
+	var min int64
+	for _, max := range chunkSizeBounds {
+		info.ChunkSizes = append(info.ChunkSizes, &ChunkSizeBucket{Min: min, Max: max})
+		min = max
+	}
+	info.ChunkSizes = append(info.ChunkSizes, &ChunkSizeBucket{Min: min})
+
+	sizes := make(map[string]int64)
+	open := make(map[string]bool)
+	metadata := map[string]*bytes.Buffer{
+		CheckpointsFile: nil,
+		InfoFile:        nil,
+		BinlogInfoFile:  nil,
+	}
+	damaged := make(map[string]error) // metadata files with a chunk that failed verification
+
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		if chunk.Type == ChunkTypeUnknown {
+			continue
+		}
+
+		path := string(chunk.Path)
+		if _, ok := sizes[path]; !ok {
+			sizes[path] = 0
+			info.Files++
+			info.countSuffix(path)
+		}
+
+		if chunk.Type == ChunkTypeEOF {
+			delete(open, path)
+			continue
+		}
+
+		open[path] = true
+		if len(open) > info.Interleaving {
+			info.Interleaving = len(open)
+		}
+
+		info.Chunks++
+		info.PayloadBytes += int64(chunk.PayLen)
+		if end := int64(chunk.PayOffset + chunk.PayLen); end > sizes[path] {
+			sizes[path] = end
+		}
+		for _, bucket := range info.ChunkSizes {
+			if bucket.Max == 0 || int64(chunk.PayLen) <= bucket.Max {
+				bucket.Count++
+				break
+			}
+		}
+
+		if buffer, ok := metadata[path]; ok && damaged[path] == nil {
+			if buffer == nil {
+				buffer = new(bytes.Buffer)
+				metadata[path] = buffer
+			}
+			if chunk.PayOffset != uint64(buffer.Len()) {
+				damaged[path] = fmt.Errorf("chunk at offset %d does not follow the %d bytes already read", chunk.PayOffset, buffer.Len())
+				continue
+			}
+			payload, err := readPayload(chunk)
+			if err != nil {
+				if !errors.Is(err, ErrChecksum) {
+					return nil, err
+				}
+				damaged[path] = err
+				continue
+			}
+			buffer.Write(payload)
+		}
+	}
+
+	info.StreamBytes = r.offset
+	for _, size := range sizes {
+		info.LogicalBytes += size
+	}
+
+	info.parseMetadata(metadata, damaged)
+
+	return info, nil
+}
+
+func (info *ArchiveInfo) countSuffix(path string) {
+	if strings.HasSuffix(path, encryptedSuffix) {
+		info.Encrypted++
+		path = strings.TrimSuffix(path, encryptedSuffix)
+	}
+	for _, suffix := range compressedSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			info.Compressed++
+			break
+		}
+	}
+}
+
+// parseMetadata fills Backup from the metadata files found in the archive, checkpoints last so the LSN range
+// it records takes precedence over xtrabackup_info. Backup is left nil unless at least one file was parsed.
+func (info *ArchiveInfo) parseMetadata(metadata map[string]*bytes.Buffer, damaged map[string]error) {
+	backup := new(BackupInfo)
+	parsed := false
+
+	parse := func(path string, fn func(io.Reader) error) {
+		buffer := metadata[path]
+		if buffer == nil {
+			return
+		}
+		err := damaged[path]
+		if err == nil {
+			err = fn(buffer)
+		}
+		if err != nil {
+			info.Warnings = append(info.Warnings, fmt.Sprintf("%s: %v", path, err))
+			return
+		}
+		parsed = true
+	}
+
+	parse(InfoFile, backup.ParseInfo)
+	parse(BinlogInfoFile, backup.ParseBinlogInfo)
+	parse(CheckpointsFile, func(r io.Reader) error {
+		c, err := ParseCheckpoints(r)
+		if err == nil {
+			backup.ApplyCheckpoints(c)
+		}
+		return err
+	})
+
+	if parsed {
+		info.Backup = backup
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarize(t *testing.T) {
+	files := []testFile{
+		{CheckpointsFile, [][]byte{[]byte("backup_type = full-backuped\nfrom_lsn = 0\nto_lsn = 18000\nlast_lsn = 18010\n")}},
+		{InfoFile, [][]byte{[]byte("server_version = 8.0.32-24\ntool_version = 8.0.32-26\n" +
+			"binlog_pos = filename 'binlog.000001', position '100'\ninnodb_to_lsn = 1\n")}},
+		{BinlogInfoFile, [][]byte{[]byte("binlog.000003\t157\t3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,\n4e11fa47-71ca-11e1-9e33-c80aa9429562:1-2\n")}},
+		{"db/t1.ibd.zst", [][]byte{bytes.Repeat([]byte{1}, 2000)}},
+		{"db/t2.ibd.qp.xbcrypt", [][]byte{bytes.Repeat([]byte{2}, 100)}},
+	}
+	archive := buildArchive(t, files...)
+
+	info, err := Summarize(NewReader(bytes.NewReader(archive)))
+	require.NoError(t, err)
+
+	var logical int64
+	for _, file := range files {
+		logical += int64(len(file.parts[0]))
+	}
+
+	assert.Equal(t, 5, info.Files)
+	assert.Equal(t, 5, info.Chunks)
+	assert.Equal(t, logical, info.LogicalBytes)
+	assert.Equal(t, logical, info.PayloadBytes)
+	assert.Equal(t, int64(len(archive)), info.StreamBytes)
+	assert.Equal(t, 1, info.Interleaving)
+	assert.Equal(t, 2, info.Compressed)
+	assert.Equal(t, 1, info.Encrypted)
+	assert.Equal(t, 4, info.ChunkSizes[0].Count)
+	assert.Equal(t, 1, info.ChunkSizes[1].Count)
+	assert.Empty(t, info.Warnings)
+
+	require.NotNil(t, info.Backup)
+	assert.Equal(t, &BackupInfo{
+		Type:           "full-backuped",
+		ToLSN:          18000,
+		LastLSN:        18010,
+		ServerVersion:  "8.0.32-24",
+		ToolVersion:    "8.0.32-26",
+		BinlogFile:     "binlog.000003",
+		BinlogPosition: 157,
+		GTID:           "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,4e11fa47-71ca-11e1-9e33-c80aa9429562:1-2",
+	}, info.Backup)
+}
+
+func TestSummarizeDamagedMetadata(t *testing.T) {
+	archive := buildArchive(t, testFile{CheckpointsFile, [][]byte{[]byte("backup_type = full-backuped\nto_lsn = 18000\n")}})
+
+	// A corrupt metadata chunk is reported instead of being parsed, leaving no backup section
+	corrupt := append([]byte(nil), archive...)
+	corrupt[len(corrupt)-len(CheckpointsFile)-15] ^= 0xff
+	info, err := Summarize(NewReader(bytes.NewReader(corrupt)))
+	require.NoError(t, err)
+	assert.Nil(t, info.Backup)
+	require.Len(t, info.Warnings, 1)
+	assert.Contains(t, info.Warnings[0], ErrChecksum.Error())
+
+	info, err = Summarize(NewReader(bytes.NewReader(buildArchive(t, testFile{CheckpointsFile, [][]byte{[]byte("to_lsn = x\n")}}))))
+	require.NoError(t, err)
+	assert.Nil(t, info.Backup)
+	assert.Len(t, info.Warnings, 1)
+}
+
+func TestParseInfoBinlogPos(t *testing.T) {
+	b := new(BackupInfo)
+	require.NoError(t, b.ParseInfo(bytes.NewBufferString(
+		"binlog_pos = filename 'binlog.000002', position '4711', GTID of the last change 'uuid:1-9'\nincremental = Y\n")))
+	assert.Equal(t, "binlog.000002", b.BinlogFile)
+	assert.Equal(t, uint64(4711), b.BinlogPosition)
+	assert.Equal(t, "uuid:1-9", b.GTID)
+	assert.Equal(t, "incremental", b.Type)
+}
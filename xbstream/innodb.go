@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+)
+
+// InnoDB on-disk layout constants used when inspecting tablespaces and redo logs stored in an archive.
+// Offsets follow the naming used by the InnoDB source (fil0fil.h, fsp0fsp.h).
+const (
+	filPageOffset  = 4  // page number within the tablespace
+	filPageLSN     = 16 // LSN of the newest modification to the page
+	filPageType    = 24 // page type
+	filPageSpaceID = 34 // tablespace id the page belongs to
+	filPageData    = 38 // start of the page payload, FSP header on page 0
+
+	fspSpaceID    = filPageData      // FSP_SPACE_ID
+	fspSize       = filPageData + 8  // FSP_SIZE, tablespace size in pages
+	fspSpaceFlags = filPageData + 16 // FSP_SPACE_FLAGS
+
+	filNull = 0xFFFFFFFF // FIL_NULL, the undefined page number
+
+	filPageTypeSDI      = 17853 // FIL_PAGE_SDI, B-tree page of the SDI index
+	filPageTypeSDIBlob  = 17854 // FIL_PAGE_SDI_BLOB, uncompressed SDI BLOB page
+	filPageTypeSDIZBlob = 17855 // FIL_PAGE_SDI_ZBLOB, compressed SDI BLOB page
+
+	pageHeader        = filPageData     // PAGE_HEADER, start of the index page header
+	pageLevel         = pageHeader + 26 // PAGE_LEVEL, 0 for leaf pages
+	pageNewInfimum    = 99              // PAGE_NEW_INFIMUM, infimum record of a compact page
+	pageNewSupremum   = 112             // PAGE_NEW_SUPREMUM, supremum record of a compact page
+	recNewExtraBytes  = 5               // REC_N_NEW_EXTRA_BYTES, compact record header size
+	recNext           = 2               // REC_NEXT, offset before the record of the next record pointer
+	recInfoDeleted    = 0x20            // REC_INFO_DELETED_FLAG
+	recStatusMask     = 0x07            // REC_NEW_STATUS_MASK
+	recStatusOrdinary = 0               // REC_STATUS_ORDINARY
+
+	btrExternFieldRefSize = 20 // BTR_EXTERN_FIELD_REF_SIZE
+	btrBlobHdrPartLen     = 0  // BTR_BLOB_HDR_PART_LEN
+	btrBlobHdrNextPageNo  = 4  // BTR_BLOB_HDR_NEXT_PAGE_NO
+	btrBlobHdrSize        = 8  // BTR_BLOB_HDR_SIZE
+
+	fspFlagsPosZipSSize  = 1  // FSP_FLAGS_POS_ZIP_SSIZE, 4 bits
+	fspFlagsPosPageSSize = 6  // FSP_FLAGS_POS_PAGE_SSIZE, 4 bits
+	fspFlagsPosEncrypted = 13 // FSP_FLAGS_POS_ENCRYPTION
+	fspFlagsPosSDI       = 14 // FSP_FLAGS_POS_SDI
+
+	// univPageSizeOrig is the page size used when the tablespace flags do not specify one
+	univPageSizeOrig = 16 * 1024
+)
+
+// pageSpaceID returns the tablespace id stored in the FIL header of page
+func pageSpaceID(page []byte) uint32 {
+	return binary.BigEndian.Uint32(page[filPageSpaceID:])
+}
+
+// isTablespace reports whether path names an InnoDB tablespace file
+func isTablespace(path string) bool {
+	base := filepath.Base(path)
+	switch {
+	case strings.HasSuffix(base, ".ibd"), strings.HasSuffix(base, ".ibu"):
+		return true
+	case strings.HasPrefix(base, "ibdata"), strings.HasPrefix(base, "undo"):
+		return !strings.Contains(base, ".")
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DefaultJournalInterval is the number of payload bytes extracted between journal checkpoints
+const DefaultJournalInterval = 256 * 1024 * 1024
+
+// journalRange identifies a chunk payload written to a file
+type journalRange struct {
+	offset   int64
+	length   int64
+	checksum uint32
+}
+
+// journal records the progress of an extraction so that it can be resumed. Each line records either a file
+// that was completely extracted and synced, or a chunk payload that was written and synced:
+//
+//	file "path"
+//	range offset length checksum "path"
+//
+// Lines are only appended after the data they describe has been synced, and the journal is synced after
+// every append, so everything it records survives a crash.
+type journal struct {
+	mutex  sync.Mutex
+	file   *os.File
+	done   map[string]bool
+	ranges map[string]map[journalRange]bool
+}
+
+// openJournal loads the journal at name, creating it when it does not exist
+func openJournal(name string) (*journal, error) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &journal{file: file, done: make(map[string]bool), ranges: make(map[string]map[journalRange]bool)}
+
+	reader := bufio.NewReader(file)
+	var complete int64 // length of the records read in full
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		complete += int64(len(line))
+		line = strings.TrimSuffix(line, "\n")
+
+		var (
+			path string
+			r    journalRange
+		)
+		switch {
+		case strings.HasPrefix(line, "file "):
+			if _, err = fmt.Sscanf(line, "file %q", &path); err == nil {
+				j.done[path] = true
+			}
+		case strings.HasPrefix(line, "range "):
+			if _, err = fmt.Sscanf(line, "range %d %d %d %q", &r.offset, &r.length, &r.checksum, &path); err == nil {
+				j.acknowledged(path)[r] = true
+			}
+		default:
+			err = fmt.Errorf("unknown record")
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: malformed journal line %q", name, line)
+		}
+	}
+
+	// A final line without its newline was being appended when the process died. It is dropped so the next
+	// record starts on a line of its own.
+	if err = file.Truncate(complete); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (j *journal) acknowledged(path string) map[journalRange]bool {
+	ranges, ok := j.ranges[path]
+	if !ok {
+		ranges = make(map[journalRange]bool)
+		j.ranges[path] = ranges
+	}
+	return ranges
+}
+
+// completed reports whether path was completely extracted
+func (j *journal) completed(path string) bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.done[path]
+}
+
+// started reports whether any of path was extracted
+func (j *journal) started(path string) bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.done[path] || len(j.ranges[path]) > 0
+}
+
+// applied reports whether the payload of chunk was written to its file
+func (j *journal) applied(chunk *Chunk) bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	r := journalRange{offset: int64(chunk.PayOffset), length: int64(chunk.PayLen), checksum: chunk.Checksum}
+	return j.ranges[string(chunk.Path)][r]
+}
+
+// complete records that path has been extracted and synced
+func (j *journal) complete(path string) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.done[path] = true
+	delete(j.ranges, path)
+	return j.append(fmt.Sprintf("file %q\n", path))
+}
+
+// acknowledge records that the ranges of path have been written and synced
+func (j *journal) acknowledge(path string, ranges []journalRange) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	var b strings.Builder
+	for _, r := range ranges {
+		j.acknowledged(path)[r] = true
+		fmt.Fprintf(&b, "range %d %d %d %q\n", r.offset, r.length, r.checksum, path)
+	}
+	return j.append(b.String())
+}
+
+func (j *journal) append(records string) error {
+	if _, err := j.file.WriteString(records); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *journal) Close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrDuplicatePath indicates two merged archives store a file at the same path
+var ErrDuplicatePath = errors.New("duplicate path")
+
+// MergeSource is an archive merged by Merge
+type MergeSource struct {
+	Reader *Reader
+	Prefix string            // prepended verbatim to every path, include a trailing slash to add a directory
+	Rename map[string]string // paths of the source replaced by a new path, instead of being prefixed
+}
+
+// Merge copies the chunks of every source into w in turn, without reassembling the files they contain.
+// Each payload is verified against its checksum before it is written. A path stored by more than one source is reported as
+// ErrDuplicatePath, since extracting the merged archive would otherwise silently overwrite one with the other.
+func Merge(w *Writer, sources []MergeSource) error {
+	owners := make(map[string]int) // index of the source each output path was read from
+
+	for i, source := range sources {
+		for {
+			chunk, err := source.Reader.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+
+			if chunk.Type == ChunkTypeUnknown {
+				continue
+			}
+
+			path, ok := source.Rename[string(chunk.Path)]
+			if !ok {
+				path = source.Prefix + string(chunk.Path)
+			}
+
+			if owner, ok := owners[path]; !ok {
+				owners[path] = i
+			} else if owner != i {
+				return fmt.Errorf("%w: %s is stored by sources %d and %d", ErrDuplicatePath, path, owner+1, i+1)
+			}
+
+			chunk.Path = []byte(path)
+			if chunk.Type == ChunkTypeEOF {
+				if err = w.WriteChunk(chunk); err != nil {
+					return err
+				}
+				continue
+			}
+
+			payload, err := readPayload(chunk)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			chunk.Reader = bytes.NewReader(payload)
+			if err = w.WriteChunk(chunk); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a := buildArchive(t, testFile{"t1.ibd", [][]byte{[]byte("one")}}, testFile{"xtrabackup_info", [][]byte{[]byte("a")}})
+	b := buildArchive(t, testFile{"t2.ibd", [][]byte{[]byte("two")}}, testFile{"xtrabackup_info", [][]byte{[]byte("b")}})
+
+	merged := nopWriteCloser{new(bytes.Buffer)}
+	err = Merge(NewWriter(merged), []MergeSource{
+		{Reader: NewReader(bytes.NewReader(a)), Prefix: "db1/", Rename: map[string]string{"xtrabackup_info": "info-a"}},
+		{Reader: NewReader(bytes.NewReader(b)), Prefix: "db2/"},
+	})
+	require.NoError(t, err)
+
+	problems, err := Verify(NewReader(bytes.NewReader(merged.Bytes())))
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	require.NoError(t, (&Extractor{Dir: dir}).Extract(NewReader(bytes.NewReader(merged.Bytes()))))
+	for path, content := range map[string]string{
+		"db1/t1.ibd": "one", "info-a": "a", "db2/t2.ibd": "two", "db2/xtrabackup_info": "b",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, path))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data), path)
+	}
+
+	err = Merge(NewWriter(nopWriteCloser{new(bytes.Buffer)}), []MergeSource{
+		{Reader: NewReader(bytes.NewReader(a))},
+		{Reader: NewReader(bytes.NewReader(b))},
+	})
+	assert.True(t, errors.Is(err, ErrDuplicatePath))
+
+	// A corrupt payload is not written to the merged archive
+	corrupt := append([]byte(nil), a...)
+	corrupt[len(splitChunks(t, a)[0])-1] ^= 0xff
+	merged.Reset()
+	err = Merge(NewWriter(merged), []MergeSource{{Reader: NewReader(bytes.NewReader(corrupt))}})
+	assert.True(t, errors.Is(err, ErrChecksum), "%v", err)
+	assert.Zero(t, merged.Len())
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// parseKeyValues parses the "key = value" text format used by xtrabackup metadata files
+func parseKeyValues(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed metadata line %q", line)
+		}
+		values[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return values, scanner.Err()
+}
+
+const (
+	// InfoFile is the name of the file recording the server and tool that took a backup
+	InfoFile = "xtrabackup_info"
+	// BinlogInfoFile is the name of the file recording the binary log position of a backup
+	BinlogInfoFile = "xtrabackup_binlog_info"
+)
+
+var binlogPosPattern = regexp.MustCompile(`filename '([^']*)', position '(\d+)'(?:, GTID of the last change '([^']*)')?`)
+
+// BackupInfo contains the description of a backup recorded by xtrabackup in its metadata files
+type BackupInfo struct {
+	Type           string
+	FromLSN        uint64
+	ToLSN          uint64
+	LastLSN        uint64
+	ServerVersion  string
+	ToolVersion    string
+	StartTime      string
+	EndTime        string
+	BinlogFile     string
+	BinlogPosition uint64
+	GTID           string
+}
+
+// ApplyCheckpoints records the backup type and LSN range from xtrabackup_checkpoints
+func (b *BackupInfo) ApplyCheckpoints(c *Checkpoints) {
+	b.Type = c.BackupType
+	b.FromLSN = c.FromLSN
+	b.ToLSN = c.ToLSN
+	b.LastLSN = c.LastLSN
+}
+
+// ParseInfo reads the contents of an xtrabackup_info file into b. The LSN range, backup type and binary log
+// position are only taken from it when b does not already hold them. Summarize parses xtrabackup_info first and
+// lets xtrabackup_binlog_info and xtrabackup_checkpoints overwrite those fields afterwards.
+func (b *BackupInfo) ParseInfo(r io.Reader) error {
+	values, err := parseKeyValues(r)
+	if err != nil {
+		return err
+	}
+
+	b.ServerVersion = values["server_version"]
+	b.ToolVersion = values["tool_version"]
+	b.StartTime = values["start_time"]
+	b.EndTime = values["end_time"]
+
+	if b.ToLSN == 0 {
+		for key, field := range map[string]*uint64{"innodb_from_lsn": &b.FromLSN, "innodb_to_lsn": &b.ToLSN} {
+			value, ok := values[key]
+			if !ok {
+				continue
+			}
+			if *field, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return fmt.Errorf("malformed info value %s = %q", key, value)
+			}
+		}
+	}
+
+	if b.Type == "" {
+		if values["incremental"] == "Y" {
+			b.Type = "incremental"
+		} else if _, ok := values["incremental"]; ok {
+			b.Type = "full-backuped"
+		}
+	}
+
+	if pos, ok := values["binlog_pos"]; ok && b.BinlogFile == "" {
+		m := binlogPosPattern.FindStringSubmatch(pos)
+		if m == nil {
+			return fmt.Errorf("malformed info value binlog_pos = %q", pos)
+		}
+		b.BinlogFile, b.GTID = m[1], m[3]
+		b.BinlogPosition, _ = strconv.ParseUint(m[2], 10, 64)
+	}
+
+	return nil
+}
+
+// ParseBinlogInfo reads the contents of an xtrabackup_binlog_info file into b. The file holds the binary log
+// name, position and optionally the executed GTID set, which may be split across several lines.
+func (b *BackupInfo) ParseBinlogInfo(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return nil
+	}
+	if len(fields) < 2 {
+		return fmt.Errorf("malformed binlog info %q", strings.TrimSpace(string(data)))
+	}
+
+	position, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed binlog position %q", fields[1])
+	}
+
+	b.BinlogFile = fields[0]
+	b.BinlogPosition = position
+	b.GTID = strings.Join(fields[2:], "")
+
+	return nil
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ObjectStore stores objects by slash separated name, such as the bucket of an object storage service
+type ObjectStore interface {
+	// Put stores the content read from r as name, replacing any object of the same name
+	Put(name string, r io.Reader) error
+	// Get opens the object stored as name, the error wraps ErrNotFound when there is none
+	Get(name string) (io.ReadCloser, error)
+	// Delete removes the object stored as name
+	Delete(name string) error
+	// List returns the names of the objects beginning with prefix in lexical order
+	List(prefix string) ([]string, error)
+}
+
+// NewDirStore returns an ObjectStore keeping each object as a file beneath dir, at the path of its name.
+// Objects are written to a temporary file and renamed into place, so a partially written object is never seen.
+func NewDirStore(dir string) ObjectStore {
+	return dirStore{dir: dir}
+}
+
+type dirStore struct {
+	dir string
+}
+
+// partialObject marks the temporary files of objects being written
+const partialObject = ".partial-"
+
+func (s dirStore) path(name string) (string, error) {
+	p := filepath.Join(s.dir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(s.dir, p); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: object name escapes the store directory", name)
+	}
+	return p, nil
+}
+
+func (s dirStore) Put(name string, r io.Reader) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+partialObject)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), p)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (s dirStore) Get(name string) (io.ReadCloser, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	return file, err
+}
+
+// Delete removes the object and then any directories it leaves empty
+func (s dirStore) Delete(name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(p); err != nil {
+		return err
+	}
+
+	root := filepath.Clean(s.dir)
+	for dir := filepath.Dir(p); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s dirStore) List(prefix string) ([]string, error) {
+	var names []string
+
+	err := filepath.Walk(s.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == s.dir {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") && strings.Contains(info.Name(), partialObject) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
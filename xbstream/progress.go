@@ -0,0 +1,50 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+// Progress reports the amount of an archive written or read so far
+type Progress struct {
+	Bytes  int64  // payload bytes
+	Chunks int64  // payload chunks
+	Files  int64  // files completed by their EOF chunk
+	Path   string // file of the most recent chunk
+}
+
+// progressCounter accumulates Progress from the chunks of an archive and reports it after each chunk
+type progressCounter struct {
+	fn       func(Progress)
+	progress Progress
+}
+
+func (c *progressCounter) chunk(chunk *Chunk) {
+	if c.fn == nil {
+		return
+	}
+
+	c.progress.Path = string(chunk.Path)
+	if chunk.Type == ChunkTypeEOF {
+		c.progress.Files++
+	} else {
+		c.progress.Chunks++
+		c.progress.Bytes += int64(chunk.PayLen)
+	}
+
+	c.fn(c.progress)
+}
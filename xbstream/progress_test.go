@@ -0,0 +1,52 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	var written []Progress
+	archive := nopWriteCloser{new(bytes.Buffer)}
+	w := NewWriter(archive)
+	w.SetProgress(func(p Progress) { written = append(written, p) })
+
+	writeFiles(t, w, testFile{"a", [][]byte{make([]byte, 10)}}, testFile{"b", [][]byte{make([]byte, 10)}})
+
+	require.Len(t, written, 4)
+	assert.Equal(t, Progress{Bytes: 20, Chunks: 2, Files: 2, Path: "b"}, written[3])
+	assert.Equal(t, Progress{Bytes: 10, Chunks: 1, Files: 0, Path: "a"}, written[0])
+
+	dir, err := ioutil.TempDir("", "progress")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var read Progress
+	e := &Extractor{Dir: dir, Filter: func(path string) bool { return path == "a" }, Progress: func(p Progress) { read = p }}
+	require.NoError(t, e.Extract(NewReader(bytes.NewReader(archive.Bytes()))))
+	assert.Equal(t, written[3], read)
+}
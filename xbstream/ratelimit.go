@@ -0,0 +1,136 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxLimitSleep bounds each sleep of a RateLimiter so a rate changed by SetRate takes effect promptly
+const maxLimitSleep = 100 * time.Millisecond
+
+// RateLimiter is a token bucket limiting the throughput of archive I/O to a number of bytes per second.
+// The bucket holds up to burst bytes, allowing short bursts above the rate after a quiet period.
+// A nil RateLimiter, or one with a rate of zero, does not limit. Its methods are safe for concurrent use.
+type RateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing rate bytes per second with bursts of up to burst bytes.
+// A burst below one second of the rate defaults to one second of the rate.
+func NewRateLimiter(rate, burst int64) *RateLimiter {
+	l := new(RateLimiter)
+	l.SetRate(rate, burst)
+	l.tokens = l.burst
+	return l
+}
+
+// SetRate changes the rate and burst of the limiter, including for callers already waiting
+func (l *RateLimiter) SetRate(rate, burst int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if burst < rate {
+		burst = rate
+	}
+	l.rate = float64(rate)
+	l.burst = float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = time.Now()
+}
+
+// Rate returns the current rate in bytes per second, zero when unlimited
+func (l *RateLimiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int64(l.rate)
+}
+
+// Wait blocks until n bytes may be transferred or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	remaining := float64(n)
+	for remaining > 0 {
+		delay := l.take(&remaining)
+		if delay == 0 {
+			continue
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// take consumes as many of the remaining bytes as the bucket allows and returns how long to wait before
+// trying again
+func (l *RateLimiter) take(remaining *float64) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.rate == 0 {
+		*remaining = 0
+		return 0
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	// Requests larger than the bucket are granted in pieces of at most burst bytes
+	want := *remaining
+	if want > l.burst {
+		want = l.burst
+	}
+	if l.tokens >= want {
+		l.tokens -= want
+		*remaining -= want
+		return 0
+	}
+
+	delay := time.Duration((want - l.tokens) / l.rate * float64(time.Second))
+	if delay > maxLimitSleep {
+		delay = maxLimitSleep
+	}
+	return delay
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var unlimited *RateLimiter
+	require.NoError(t, unlimited.Wait(context.Background(), 1<<30))
+
+	// A wait that is granted without sleeping succeeds even with a done context
+	done, cancelDone := context.WithCancel(context.Background())
+	cancelDone()
+
+	l := NewRateLimiter(1000, 100)
+
+	// The initial burst is one second of the rate
+	require.NoError(t, l.Wait(done, 1000))
+
+	start := time.Now()
+	require.NoError(t, l.Wait(context.Background(), 200))
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 150*time.Millisecond, "waited %s", elapsed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, l.Wait(ctx, 10000))
+
+	// Removing the limit releases callers without waiting for the bucket
+	l.SetRate(0, 0)
+	require.NoError(t, l.Wait(done, 1<<30))
+}
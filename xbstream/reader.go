@@ -23,19 +23,34 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 )
 
 // Reader provides sequential access to chunks from an xbstream. Each chunk returned represents a
 // contiguous set of bytes for a file stored in the xbstream archive. The Next method advances the stream
 // and returns the next chunk in the archive. Each archive then acts as a reader for its contiguous set of bytes
+//
+// Payloads are read directly from the underlying reader, so a chunk may only be read until the next call to Next.
+// Any payload left unread is skipped by Next, using Seek when the underlying reader supports it.
 type Reader struct {
-	reader io.Reader
+	source  io.Reader
+	reader  *countingReader
+	offset  int64 // bytes consumed from source
+	start   int64 // stream offset of the chunk most recently parsed by Next
+	payload *payloadReader
+	noSeek  bool // source does not implement io.Seeker, seeking it failed or bytes were pushed back by Resync
 }
 
 // NewReader creates a new Reader by wrapping the provided reader
 func NewReader(reader io.Reader) *Reader {
-	return &Reader{reader: reader}
+	r := &Reader{source: reader}
+	r.reader = &countingReader{reader: reader, count: &r.offset}
+	_, seekable := reader.(io.Seeker)
+	r.noSeek = !seekable
+	return r
 }
 
 // Next advances the Reader and returns the next Chunk.
@@ -46,77 +61,199 @@ func (r *Reader) Next() (*Chunk, error) {
 		err   error
 	)
 
+	if r.payload != nil {
+		err = r.skip(r.payload.remaining)
+		r.payload = nil
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.start = r.offset
+	chunk.Offset = r.offset
 	chunk.Magic = make([]uint8, len(chunkMagic))
 
 	// Chunk Magic
 	if err = binary.Read(r.reader, binary.BigEndian, &chunk.Magic); err != nil {
 		// We should gracefully bubble up EOF if we attempt to read a new Chunk and hit EOF
 		if err != io.EOF {
-			return nil, ErrStreamRead
+			return nil, streamError(err)
 		}
 
 		return nil, err
 	}
 
 	if bytes.Compare(chunk.Magic, chunkMagic) != 0 {
-		return nil, errors.New("wrong chunk magic")
+		return nil, ErrWrongMagic
 	}
 
 	// Chunk Flags
 	if err = binary.Read(r.reader, binary.LittleEndian, &chunk.Flags); err != nil {
-		return nil, ErrStreamRead
+		return nil, streamError(err)
 	}
 
 	// Chunk Type
 	if err = binary.Read(r.reader, binary.LittleEndian, &chunk.Type); err != nil {
-		return nil, ErrStreamRead
+		return nil, streamError(err)
 	}
 	if chunk.Type = validateChunkType(chunk.Type); chunk.Type == ChunkTypeUnknown {
 		if !(chunk.Flags&FlagChunkIgnorable == 1) {
-			return nil, errors.New("unknown chunk type")
+			return nil, ErrUnknownChunkType
 		}
 	}
 
 	// Path Length
 	if err = binary.Read(r.reader, binary.LittleEndian, &chunk.PathLen); err != nil {
-		return nil, ErrStreamRead
+		return nil, streamError(err)
+	}
+
+	if chunk.PathLen > MaxPathLength {
+		return nil, ErrPathLength
 	}
 
 	// Path
 	if chunk.PathLen > 0 {
 		chunk.Path = make([]uint8, chunk.PathLen)
 		if err = binary.Read(r.reader, binary.BigEndian, &chunk.Path); err != nil {
-			return nil, ErrStreamRead
+			return nil, streamError(err)
 		}
 	}
 
 	if chunk.Type == ChunkTypeEOF {
+		chunk.Reader = bytes.NewReader(nil)
 		return chunk, nil
 	}
 
 	if err = binary.Read(r.reader, binary.LittleEndian, &chunk.PayLen); err != nil {
-		return nil, ErrStreamRead
+		return nil, streamError(err)
+	}
+
+	// A corrupt length would be skipped by seeking backwards or far beyond the end of the stream
+	if chunk.PayLen > maxPayloadLength {
+		return nil, ErrPayloadLength
 	}
 
 	if err = binary.Read(r.reader, binary.LittleEndian, &chunk.PayOffset); err != nil {
-		return nil, ErrStreamRead
+		return nil, streamError(err)
 	}
 
 	if err = binary.Read(r.reader, binary.LittleEndian, &chunk.Checksum); err != nil {
-		return nil, ErrStreamRead
+		return nil, streamError(err)
 	}
 
-	if chunk.PayLen > 0 {
-		buffer := bytes.NewBuffer(nil)
-		if _, err := io.CopyN(buffer, r.reader, int64(chunk.PayLen)); err != nil {
-			return nil, ErrStreamRead
+	r.payload = &payloadReader{reader: r.reader, remaining: int64(chunk.PayLen)}
+	chunk.Reader = r.payload
+
+	return chunk, nil
+}
+
+// Resync discards bytes until the next chunk magic so that Next can continue after a chunk that could not be
+// parsed. Scanning starts at the current position of the stream, so the unread payload of the last chunk is
+// searched rather than skipped. Resync returns io.EOF when the stream ends without another magic.
+func (r *Reader) Resync() error {
+	r.payload = nil
+	buf := make([]byte, 64*1024)
+	kept := 0 // bytes carried over from the previous read that may hold the start of a magic
+
+	for {
+		n, err := r.reader.Read(buf[kept:])
+		n += kept
+
+		if i := bytes.Index(buf[:n], chunkMagic); i >= 0 {
+			r.unread(buf[i:n])
+			return nil
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				return io.EOF
+			}
+			return streamError(err)
+		}
+
+		if n >= len(chunkMagic) {
+			kept = copy(buf, buf[n-len(chunkMagic)+1:n])
+		} else {
+			kept = n
 		}
-		chunk.Reader = buffer
-	} else {
-		chunk.Reader = bytes.NewReader(nil)
 	}
+}
 
-	return chunk, nil
+// unread pushes p back onto the stream to be read again by the next read
+func (r *Reader) unread(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	r.reader.reader = io.MultiReader(bytes.NewReader(append([]byte(nil), p...)), r.reader.reader)
+	r.offset -= int64(len(p))
+	// Seeking the source would skip over the pushed back bytes
+	r.noSeek = true
+}
+
+// skip discards n bytes of the stream
+func (r *Reader) skip(n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if n < 0 {
+		return fmt.Errorf("%w: can not skip %d bytes", ErrStreamRead, n)
+	}
+
+	if !r.noSeek {
+		seeker := r.source.(io.Seeker)
+		if pos, err := seeker.Seek(n, io.SeekCurrent); err == nil {
+			r.offset += n
+
+			// Seeking beyond the end is not an error, so the size is checked for a payload the stream ends within
+			end, err := seeker.Seek(0, io.SeekEnd)
+			if err == nil && end < pos {
+				return ErrTruncated
+			}
+			if err == nil {
+				_, err = seeker.Seek(pos, io.SeekStart)
+			}
+			if err != nil {
+				return streamError(err)
+			}
+			return nil
+		}
+		// Seek is not supported by every io.Seeker, such as an os.File wrapping a pipe
+		r.noSeek = true
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, r.reader, n); err != nil {
+		return streamError(err)
+	}
+	return nil
+}
+
+// readPayload reads the payload of chunk into memory and verifies it against the chunk checksum
+func readPayload(chunk *Chunk) ([]byte, error) {
+	if chunk.PayLen > maxPayloadLength {
+		return nil, fmt.Errorf("%w %d at offset %d", ErrPayloadLength, chunk.PayLen, chunk.PayOffset)
+	}
+
+	payload := make([]byte, chunk.PayLen)
+	if _, err := io.ReadFull(chunk, payload); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != chunk.Checksum {
+		return nil, fmt.Errorf("%w at offset %d", ErrChecksum, chunk.PayOffset)
+	}
+
+	return payload, nil
+}
+
+// streamError maps an error encountered while reading a chunk to the error returned by Next
+func streamError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	if errors.Is(err, ErrVolumeSequence) {
+		// Already describes the failure, and must remain distinguishable from a failed read
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrStreamRead, err)
 }
 
 func validateChunkType(p ChunkType) ChunkType {
@@ -129,3 +266,44 @@ func validateChunkType(p ChunkType) ChunkType {
 		return ChunkTypeUnknown
 	}
 }
+
+// countingReader tracks the number of bytes read from the wrapped reader
+type countingReader struct {
+	reader io.Reader
+	count  *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	*c.count += int64(n)
+	return n, err
+}
+
+// payloadReader reads the payload of a chunk from the stream, reporting ErrTruncated if the stream ends early
+type payloadReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (p *payloadReader) Read(b []byte) (int, error) {
+	if p.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > p.remaining {
+		b = b[:p.remaining]
+	}
+
+	n, err := p.reader.Read(b)
+	p.remaining -= int64(n)
+
+	if err == io.EOF {
+		if p.remaining > 0 {
+			return n, ErrTruncated
+		}
+		err = nil
+	} else if err != nil {
+		err = streamError(err)
+	}
+
+	return n, err
+}
@@ -21,9 +21,11 @@ package xbstream
 
 import (
 	"bytes"
-	"testing"
-
+	"encoding/binary"
+	"errors"
 	"io"
+	"io/ioutil"
+	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -125,3 +127,57 @@ func TestNewReader(t *testing.T) {
 	_, err = reader.Next()
 	assert.Equal(t, err, io.EOF)
 }
+
+func TestReaderSkipsUnreadPayload(t *testing.T) {
+	// A non seekable reader exercises discarding payloads, bytes.Reader exercises seeking
+	for _, source := range []io.Reader{io.MultiReader(bytes.NewReader(xbFile)), bytes.NewReader(xbFile)} {
+		reader := NewReader(source)
+
+		var paths []string
+		for {
+			chunk, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			paths = append(paths, string(chunk.Path))
+		}
+		assert.Equal(t, []string{"file1", "file1", "file2", "file2"}, paths)
+	}
+}
+
+func TestReaderTruncatedPayload(t *testing.T) {
+	reader := NewReader(bytes.NewReader(xbFile[:42]))
+
+	chunk, err := reader.Next()
+	require.NoError(t, err)
+
+	_, err = io.Copy(ioutil.Discard, chunk)
+	assert.Equal(t, ErrTruncated, err)
+	assert.True(t, errors.Is(err, ErrStreamRead))
+}
+
+func TestReaderPayloadLength(t *testing.T) {
+	// A length with the high bit set would seek backwards and return the same chunk forever
+	header := append([]byte(nil), xbFile[:40]...)
+	binary.LittleEndian.PutUint64(header[19:], 1<<63)
+	reader := NewReader(bytes.NewReader(header))
+
+	_, err := reader.Next()
+	assert.Equal(t, ErrPayloadLength, err)
+
+	assert.Error(t, reader.skip(-1))
+}
+
+func TestReaderTruncatedSkippedPayload(t *testing.T) {
+	// A seek beyond the end of the stream is not an error, the truncation must still be reported
+	for _, source := range []io.Reader{io.MultiReader(bytes.NewReader(xbFile[:40])), bytes.NewReader(xbFile[:40])} {
+		reader := NewReader(source)
+
+		_, err := reader.Next()
+		require.NoError(t, err)
+
+		_, err = reader.Next()
+		assert.Equal(t, ErrTruncated, err)
+	}
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+)
+
+const (
+	// RedoLogFile is the name of the redo log copied by xtrabackup into the archive
+	RedoLogFile = "xtrabackup_logfile"
+	// CheckpointsFile is the name of the file recording the LSN range of a backup
+	CheckpointsFile = "xtrabackup_checkpoints"
+
+	logBlockSize    = 512
+	logHeaderSize   = 4 * logBlockSize // LOG_FILE_HDR_SIZE
+	logCheckpoint1  = logBlockSize     // LOG_CHECKPOINT_1
+	logCheckpoint2  = 3 * logBlockSize // LOG_CHECKPOINT_2
+	logBlockTrlSize = 4                // LOG_BLOCK_TRL_SIZE
+	logChecksumOff  = logBlockSize - logBlockTrlSize
+
+	logHeaderFormat     = 0  // LOG_HEADER_FORMAT
+	logHeaderStartLSN   = 8  // LOG_HEADER_START_LSN
+	logHeaderCreator    = 16 // LOG_HEADER_CREATOR
+	logHeaderCreatorLen = 32
+
+	logHeaderStartLSNLegacy = 4 // LOG_FILE_START_LSN in formats before MySQL 5.7.9
+
+	logCheckpointNo  = 0 // LOG_CHECKPOINT_NO
+	logCheckpointLSN = 8 // LOG_CHECKPOINT_LSN
+
+	logBlockHdrNo      = 0 // LOG_BLOCK_HDR_NO
+	logBlockHdrDataLen = 4 // LOG_BLOCK_HDR_DATA_LEN
+	logBlockFlushBit   = 0x80000000
+
+	logNoChecksumMagic = 0xDEADBEEF // LOG_NO_CHECKSUM_MAGIC
+
+	// RedoLogFormatLegacy is the header format of redo logs written before MySQL 5.7.9
+	RedoLogFormatLegacy = 0
+	// RedoLogFormat8030 is the header format introduced by MySQL 8.0.30, checkpoints no longer carry a number
+	RedoLogFormat8030 = 6
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checkpoints contains the LSN information recorded by xtrabackup in xtrabackup_checkpoints
+type Checkpoints struct {
+	BackupType string
+	FromLSN    uint64
+	ToLSN      uint64
+	LastLSN    uint64
+	FlushedLSN uint64
+}
+
+// ParseCheckpoints parses the contents of an xtrabackup_checkpoints file
+func ParseCheckpoints(r io.Reader) (*Checkpoints, error) {
+	values, err := parseKeyValues(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Checkpoints{BackupType: values["backup_type"]}
+	for key, field := range map[string]*uint64{
+		"from_lsn":    &c.FromLSN,
+		"to_lsn":      &c.ToLSN,
+		"last_lsn":    &c.LastLSN,
+		"flushed_lsn": &c.FlushedLSN,
+	} {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		if *field, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("malformed checkpoints value %s = %q", key, value)
+		}
+	}
+
+	if c.BackupType == "" {
+		return nil, errors.New("checkpoints missing backup_type")
+	}
+
+	return c, nil
+}
+
+// RedoLogInfo describes the header, checkpoints and extent of a copied redo log
+type RedoLogInfo struct {
+	Format        uint32
+	Creator       string
+	StartLSN      uint64
+	CheckpointNo  uint64
+	CheckpointLSN uint64
+	EndLSN        uint64 // LSN following the last valid log record byte
+	Blocks        int64  // number of valid log blocks following the header
+	TrailingBytes int64  // bytes after the last valid block, a partial block indicates truncation
+}
+
+// InspectRedoLog parses the header and checkpoint blocks of an xtrabackup_logfile read from r and scans its
+// log blocks to determine the LSN the copy ends at. The reader is consumed to EOF.
+func InspectRedoLog(r io.Reader) (*RedoLogInfo, error) {
+	header := make([]byte, logHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errors.New("redo log is shorter than its header")
+		}
+		return nil, err
+	}
+
+	info := &RedoLogInfo{Format: binary.BigEndian.Uint32(header[logHeaderFormat:])}
+	info.Creator = string(bytes.TrimRight(header[logHeaderCreator:logHeaderCreator+logHeaderCreatorLen], "\x00 "))
+
+	if info.Format == RedoLogFormatLegacy {
+		info.StartLSN = binary.BigEndian.Uint64(header[logHeaderStartLSNLegacy:])
+	} else {
+		if !validLogBlock(header[:logBlockSize]) {
+			return nil, errors.New("redo log header block checksum mismatch")
+		}
+		info.StartLSN = binary.BigEndian.Uint64(header[logHeaderStartLSN:])
+	}
+
+	found := false
+	for _, offset := range []int{logCheckpoint1, logCheckpoint2} {
+		block := header[offset : offset+logBlockSize]
+		if info.Format != RedoLogFormatLegacy && !validLogBlock(block) {
+			continue
+		}
+
+		var no, lsn uint64
+		if info.Format >= RedoLogFormat8030 {
+			lsn = binary.BigEndian.Uint64(block)
+		} else {
+			no = binary.BigEndian.Uint64(block[logCheckpointNo:])
+			lsn = binary.BigEndian.Uint64(block[logCheckpointLSN:])
+		}
+
+		if !found || no > info.CheckpointNo || (no == info.CheckpointNo && lsn > info.CheckpointLSN) {
+			info.CheckpointNo, info.CheckpointLSN = no, lsn
+			found = true
+		}
+	}
+	if !found {
+		return nil, errors.New("redo log has no valid checkpoint")
+	}
+
+	info.EndLSN = info.StartLSN
+	block := make([]byte, logBlockSize)
+	lsn := info.StartLSN - info.StartLSN%logBlockSize
+	scanning := true
+
+	for {
+		n, err := io.ReadFull(r, block)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			info.TrailingBytes += int64(n)
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !scanning {
+			info.TrailingBytes += logBlockSize
+			continue
+		}
+
+		dataLen := uint64(binary.BigEndian.Uint16(block[logBlockHdrDataLen:]))
+		if binary.BigEndian.Uint32(block[logBlockHdrNo:])&^logBlockFlushBit != logBlockNo(lsn) ||
+			!validLogBlock(block) || dataLen == 0 || dataLen > logBlockSize {
+			scanning = false
+			info.TrailingBytes += logBlockSize
+			continue
+		}
+
+		info.Blocks++
+		info.EndLSN = lsn + dataLen
+		lsn += logBlockSize
+		scanning = dataLen == logBlockSize
+	}
+
+	return info, nil
+}
+
+// Validate cross-checks the redo log against the LSNs recorded in xtrabackup_checkpoints and returns every
+// inconsistency found
+func (info *RedoLogInfo) Validate(c *Checkpoints) []error {
+	var problems []error
+
+	if info.TrailingBytes%logBlockSize != 0 {
+		problems = append(problems, fmt.Errorf("redo log ends with a partial block of %d bytes", info.TrailingBytes%logBlockSize))
+	}
+	if info.CheckpointLSN != c.ToLSN {
+		problems = append(problems, fmt.Errorf("redo log checkpoint lsn %d does not match to_lsn %d", info.CheckpointLSN, c.ToLSN))
+	}
+	if info.StartLSN > c.ToLSN {
+		problems = append(problems, fmt.Errorf("redo log starts at lsn %d after to_lsn %d", info.StartLSN, c.ToLSN))
+	}
+	if info.EndLSN < c.LastLSN {
+		problems = append(problems, fmt.Errorf("redo log ends at lsn %d before last_lsn %d", info.EndLSN, c.LastLSN))
+	}
+
+	return problems
+}
+
+// logBlockNo converts an lsn to the number of the log block containing it
+func logBlockNo(lsn uint64) uint32 {
+	return uint32((lsn/logBlockSize)&0x3FFFFFFF) + 1
+}
+
+// validLogBlock verifies the trailing checksum of a log block using any of the algorithms InnoDB may have used
+func validLogBlock(block []byte) bool {
+	checksum := binary.BigEndian.Uint32(block[logChecksumOff:])
+
+	return checksum == crc32.Checksum(block[:logChecksumOff], crc32cTable) ||
+		checksum == logBlockChecksumInnodb(block[:logChecksumOff]) ||
+		checksum == logNoChecksumMagic
+}
+
+// logBlockChecksumInnodb implements the legacy log_block_calc_checksum_innodb algorithm
+func logBlockChecksumInnodb(data []byte) uint32 {
+	sum := uint32(1)
+	sh := uint(0)
+
+	for _, b := range data {
+		sum &= 0x7FFFFFFF
+		sum += uint32(b)
+		sum += uint32(b) << sh
+		sh++
+		if sh > 24 {
+			sh = 0
+		}
+	}
+
+	return sum
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sealLogBlock(block []byte) {
+	binary.BigEndian.PutUint32(block[logChecksumOff:], crc32.Checksum(block[:logChecksumOff], crc32cTable))
+}
+
+// buildRedoLog creates an xtrabackup_logfile starting at startLSN holding full blocks followed by one block
+// containing lastLen bytes
+func buildRedoLog(startLSN, checkpointLSN uint64, full int, lastLen uint16) []byte {
+	header := make([]byte, logHeaderSize)
+	binary.BigEndian.PutUint32(header[logHeaderFormat:], 1)
+	binary.BigEndian.PutUint64(header[logHeaderStartLSN:], startLSN)
+	copy(header[logHeaderCreator:], "xtrabkup test")
+	sealLogBlock(header[:logBlockSize])
+
+	checkpoint := header[logCheckpoint1 : logCheckpoint1+logBlockSize]
+	binary.BigEndian.PutUint64(checkpoint[logCheckpointNo:], 3)
+	binary.BigEndian.PutUint64(checkpoint[logCheckpointLSN:], checkpointLSN)
+	sealLogBlock(checkpoint)
+
+	log := bytes.NewBuffer(header)
+	lsn := startLSN
+	for i := 0; i <= full; i++ {
+		block := make([]byte, logBlockSize)
+		binary.BigEndian.PutUint32(block[logBlockHdrNo:], logBlockNo(lsn))
+		dataLen := uint16(logBlockSize)
+		if i == full {
+			dataLen = lastLen
+		}
+		binary.BigEndian.PutUint16(block[logBlockHdrDataLen:], dataLen)
+		sealLogBlock(block)
+		log.Write(block)
+		lsn += logBlockSize
+	}
+
+	return log.Bytes()
+}
+
+func TestInspectRedoLog(t *testing.T) {
+	info, err := InspectRedoLog(bytes.NewReader(buildRedoLog(8192, 8300, 2, 100)))
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(1), info.Format)
+	assert.Equal(t, "xtrabkup test", info.Creator)
+	assert.Equal(t, uint64(8192), info.StartLSN)
+	assert.Equal(t, uint64(3), info.CheckpointNo)
+	assert.Equal(t, uint64(8300), info.CheckpointLSN)
+	assert.Equal(t, uint64(8192+2*logBlockSize+100), info.EndLSN)
+	assert.Equal(t, int64(3), info.Blocks)
+	assert.Equal(t, int64(0), info.TrailingBytes)
+
+	checkpoints, err := ParseCheckpoints(strings.NewReader("backup_type = full-backuped\nfrom_lsn = 0\nto_lsn = 8300\nlast_lsn = 9000\n"))
+	require.NoError(t, err)
+	assert.Empty(t, info.Validate(checkpoints))
+
+	checkpoints.LastLSN = 9500
+	assert.Len(t, info.Validate(checkpoints), 1, "redo log ending before last_lsn must be reported")
+}
+
+func TestInspectRedoLogTruncated(t *testing.T) {
+	log := buildRedoLog(8192, 8300, 2, 100)
+
+	info, err := InspectRedoLog(bytes.NewReader(log[:len(log)-200]))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8192+2*logBlockSize), info.EndLSN)
+	assert.Equal(t, int64(logBlockSize-200), info.TrailingBytes)
+
+	_, err = InspectRedoLog(bytes.NewReader(log[:logHeaderSize-1]))
+	assert.Error(t, err)
+}
+
+func TestLogBlockChecksumInnodb(t *testing.T) {
+	block := make([]byte, logBlockSize)
+	binary.BigEndian.PutUint32(block[logChecksumOff:], logBlockChecksumInnodb(block[:logChecksumOff]))
+	assert.True(t, validLogBlock(block))
+
+	block[10] = 1
+	assert.False(t, validLogBlock(block))
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// RepairStatus describes how much of a file Repair was able to salvage
+type RepairStatus int
+
+const (
+	// RepairComplete indicates every byte of the file and its EOF chunk were recovered
+	RepairComplete RepairStatus = iota + 1
+	// RepairPartial indicates some of the file was recovered, see Missing and EOF
+	RepairPartial
+	// RepairLost indicates none of the content of the file could be recovered
+	RepairLost
+)
+
+func (s RepairStatus) String() string {
+	switch s {
+	case RepairComplete:
+		return "complete"
+	case RepairPartial:
+		return "partial"
+	case RepairLost:
+		return "lost"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the status by name
+func (s RepairStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// RepairedFile describes the state of a file after Repair
+type RepairedFile struct {
+	Path    string
+	Status  RepairStatus
+	Size    int64       // end of the furthest recovered payload
+	Missing []ByteRange // ranges below Size that were not recovered
+	Dropped int         // chunks discarded because their payload did not match its checksum
+	EOF     bool        // the EOF chunk was found, a missing tail can not be detected without it
+}
+
+// RepairReport lists the files found by Repair and the damage encountered in the archive
+type RepairReport struct {
+	Files  []*RepairedFile
+	Damage []*Problem
+}
+
+// Repair copies every valid chunk of the archive read from r into w. Chunks that can not be parsed are skipped
+// by resynchronising on the next chunk magic and chunks that do not match their checksum are dropped.
+// Files without an EOF chunk are terminated with one, so the output is always a well-formed archive.
+// A chunk whose header is damaged can not be attributed to a file, so data lost with it is only visible in
+// Damage: a file is reported as complete when the chunks found for it are contiguous and end with an EOF chunk.
+// The returned error is only set when the underlying stream or w fails.
+func Repair(w *Writer, r *Reader) (*RepairReport, error) {
+	report := new(RepairReport)
+	files := make(map[string]*repairState)
+
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			if errors.Is(err, ErrStreamRead) && !errors.Is(err, ErrTruncated) {
+				return nil, err
+			}
+
+			report.damage(r.start, "", err)
+			if err = r.Resync(); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, err
+			}
+			continue
+		}
+
+		if chunk.Type == ChunkTypeUnknown {
+			continue
+		}
+
+		path := string(chunk.Path)
+		state, ok := files[path]
+		if !ok {
+			state = &repairState{file: &RepairedFile{Path: path}}
+			files[path] = state
+			report.Files = append(report.Files, state.file)
+		}
+
+		if state.file.EOF {
+			report.damage(chunk.Offset, path, errors.New("dropped chunk following the EOF chunk"))
+			continue
+		}
+
+		if chunk.Type == ChunkTypeEOF {
+			state.file.EOF = true
+			if len(state.ranges) == 0 && state.file.Dropped > 0 {
+				// Nothing of the file was recovered, it is left out of the output rather than stored empty
+				continue
+			}
+			if err = w.WriteChunk(chunk); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		payload := make([]byte, chunk.PayLen)
+		if _, err = io.ReadFull(chunk, payload); err != nil {
+			if !errors.Is(err, ErrTruncated) {
+				return nil, err
+			}
+			report.damage(chunk.Offset, path, err)
+			break
+		}
+
+		if crc32.ChecksumIEEE(payload) != chunk.Checksum {
+			report.damage(chunk.Offset, path, fmt.Errorf("dropped chunk at file offset %d: %w", chunk.PayOffset, ErrChecksum))
+			state.file.Dropped++
+
+			// The payload length may be what was damaged, so the payload is searched for the next chunk
+			r.unread(payload)
+			if err = r.Resync(); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, err
+			}
+			continue
+		}
+
+		chunk.Reader = bytes.NewReader(payload)
+		if err = w.WriteChunk(chunk); err != nil {
+			return nil, err
+		}
+		state.ranges = append(state.ranges, ByteRange{Offset: int64(chunk.PayOffset), Length: int64(chunk.PayLen)})
+	}
+
+	for _, file := range report.Files {
+		state := files[file.Path]
+		state.summarise()
+
+		if !file.EOF && file.Status != RepairLost {
+			eof := &Chunk{ChunkHeader: ChunkHeader{Type: ChunkTypeEOF, Path: []byte(file.Path)}}
+			if err := w.WriteChunk(eof); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func (report *RepairReport) damage(offset int64, path string, err error) {
+	class := ProblemCorrupt
+	switch {
+	case errors.Is(err, ErrTruncated):
+		class = ProblemTruncated
+	case errors.Is(err, ErrChecksum):
+		class = ProblemChecksum
+	}
+	report.Damage = append(report.Damage, &Problem{Class: class, Path: path, Offset: offset, Message: err.Error()})
+}
+
+type repairState struct {
+	file   *RepairedFile
+	ranges []ByteRange // payload ranges written to the output
+}
+
+// summarise computes the size, missing ranges and status of the file from the ranges recovered
+func (s *repairState) summarise() {
+	sort.Slice(s.ranges, func(i, j int) bool { return s.ranges[i].Offset < s.ranges[j].Offset })
+
+	var end int64
+	for _, r := range s.ranges {
+		if r.Offset > end {
+			s.file.Missing = append(s.file.Missing, ByteRange{Offset: end, Length: r.Offset - end})
+		}
+		if r.Offset+r.Length > end {
+			end = r.Offset + r.Length
+		}
+	}
+	s.file.Size = end
+
+	switch {
+	case len(s.ranges) == 0 && s.file.Dropped > 0:
+		s.file.Status = RepairLost
+	case len(s.file.Missing) > 0 || s.file.Dropped > 0 || !s.file.EOF:
+		s.file.Status = RepairPartial
+	default:
+		s.file.Status = RepairComplete
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair(t *testing.T) {
+	part := func(b byte) []byte { return bytes.Repeat([]byte{b}, 100) }
+	archive := buildArchive(t,
+		testFile{"a", [][]byte{part(1), part(2), part(3)}},
+		testFile{"b", [][]byte{part(1)}},
+		testFile{"c", [][]byte{part(1)}},
+	)
+
+	// a0 a1 a2 aEOF b0 bEOF c0 cEOF
+	chunks := splitChunks(t, archive)
+	require.Len(t, chunks, 8)
+
+	corrupt := func(chunk []byte) []byte {
+		c := append([]byte(nil), chunk...)
+		c[len(c)-1] ^= 0xff
+		return c
+	}
+
+	damaged := bytes.Join([][]byte{
+		chunks[0], corrupt(chunks[1]), chunks[2], chunks[3],
+		[]byte("garbage between chunks"),
+		corrupt(chunks[4]), chunks[5],
+		chunks[6], chunks[7][:5],
+	}, nil)
+
+	out := nopWriteCloser{new(bytes.Buffer)}
+	report, err := Repair(NewWriter(out), NewReader(bytes.NewReader(damaged)))
+	require.NoError(t, err)
+
+	assert.Equal(t, []*RepairedFile{
+		{Path: "a", Status: RepairPartial, Size: 300, Missing: []ByteRange{{Offset: 100, Length: 100}}, Dropped: 1, EOF: true},
+		{Path: "b", Status: RepairLost, Dropped: 1, EOF: true},
+		{Path: "c", Status: RepairPartial, Size: 100},
+	}, report.Files)
+
+	var classes []ProblemClass
+	for _, problem := range report.Damage {
+		classes = append(classes, problem.Class)
+	}
+	assert.Equal(t, []ProblemClass{ProblemChecksum, ProblemCorrupt, ProblemChecksum, ProblemTruncated}, classes)
+
+	index, err := BuildIndex(NewReader(bytes.NewReader(out.Bytes())))
+	require.NoError(t, err)
+	require.Len(t, index, 2)
+	assert.Equal(t, &IndexEntry{Path: "a", Size: 300, Chunks: 2, FirstOffset: index[0].FirstOffset, LastOffset: index[0].LastOffset, EOF: true}, index[0])
+	assert.Equal(t, "c", index[1].Path)
+	assert.True(t, index[1].EOF, "missing EOF chunk is synthesised")
+}
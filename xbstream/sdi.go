@@ -0,0 +1,416 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// Offsets of the SDI index fields relative to the origin of a record, the data column is the only variable
+// length field and is preceded by type, id, DB_TRX_ID, DB_ROLL_PTR and the two length columns
+const (
+	sdiRecType       = 0
+	sdiRecID         = 4
+	sdiRecUncompLen  = 25
+	sdiRecCompLen    = 29
+	sdiRecData       = 33
+	sdiMaxRecordSize = 64 * 1024 * 1024
+)
+
+// SDI object types stored in the type column of an SDI record
+const (
+	SDITypeTable      = 1
+	SDITypeTablespace = 2
+)
+
+// ErrUnsupportedTablespace indicates a tablespace whose pages can not be read without additional processing
+var ErrUnsupportedTablespace = errors.New("unsupported tablespace")
+
+// SDIRecord is a single serialized dictionary information record stored in a MySQL 8.0 tablespace
+type SDIRecord struct {
+	Type uint32
+	ID   uint64
+	Data json.RawMessage // decompressed JSON document
+}
+
+// SDITable is the subset of a table definition decoded from a table SDI record
+type SDITable struct {
+	Schema  string
+	Name    string
+	Columns []SDIColumn
+	Indexes []SDIIndex
+}
+
+// SDIColumn describes a user visible column of a table
+type SDIColumn struct {
+	Name     string
+	Type     string
+	Nullable bool
+}
+
+// SDIIndex describes an index of a table and the columns it covers
+type SDIIndex struct {
+	Name    string
+	Type    string
+	Columns []string
+}
+
+var sdiIndexTypes = map[int]string{1: "PRIMARY", 2: "UNIQUE", 3: "MULTIPLE", 4: "FULLTEXT", 5: "SPATIAL"}
+
+// Table decodes the table definition held by a record of type SDITypeTable
+func (s *SDIRecord) Table() (*SDITable, error) {
+	if s.Type != SDITypeTable {
+		return nil, fmt.Errorf("sdi record of type %d is not a table", s.Type)
+	}
+
+	var doc struct {
+		Object struct {
+			Name    string `json:"name"`
+			Schema  string `json:"schema_ref"`
+			Columns []struct {
+				Name     string          `json:"name"`
+				Type     string          `json:"column_type_utf8"`
+				Nullable bool            `json:"is_nullable"`
+				Hidden   json.RawMessage `json:"hidden"`
+			} `json:"columns"`
+			Indexes []struct {
+				Name     string `json:"name"`
+				Type     int    `json:"type"`
+				Hidden   bool   `json:"hidden"`
+				Elements []struct {
+					Column int  `json:"column_opx"`
+					Hidden bool `json:"hidden"`
+				} `json:"elements"`
+			} `json:"indexes"`
+		} `json:"dd_object"`
+	}
+	if err := json.Unmarshal(s.Data, &doc); err != nil {
+		return nil, err
+	}
+
+	table := &SDITable{Schema: doc.Object.Schema, Name: doc.Object.Name}
+
+	for _, column := range doc.Object.Columns {
+		// Versions before 8.0.16 store hidden as a boolean, later versions use 1 for visible columns
+		if hidden := string(column.Hidden); hidden != "" && hidden != "false" && hidden != "1" {
+			continue
+		}
+		table.Columns = append(table.Columns, SDIColumn{Name: column.Name, Type: column.Type, Nullable: column.Nullable})
+	}
+
+	for _, index := range doc.Object.Indexes {
+		if index.Hidden {
+			continue
+		}
+
+		idx := SDIIndex{Name: index.Name, Type: sdiIndexTypes[index.Type]}
+		for _, element := range index.Elements {
+			if element.Hidden || element.Column < 0 || element.Column >= len(doc.Object.Columns) {
+				continue
+			}
+			idx.Columns = append(idx.Columns, doc.Object.Columns[element.Column].Name)
+		}
+		table.Indexes = append(table.Indexes, idx)
+	}
+
+	return table, nil
+}
+
+// ReadSDI reads a MySQL 8.0 tablespace from r and returns the SDI records stored within it.
+// Tablespaces using compressed or encrypted pages are reported as ErrUnsupportedTablespace.
+func ReadSDI(r io.Reader) ([]SDIRecord, error) {
+	s := new(sdiScanner)
+	if _, err := io.Copy(s, r); err != nil {
+		return nil, err
+	}
+	return s.Records()
+}
+
+// TablespaceSDI holds the SDI records read from a tablespace member of an archive
+type TablespaceSDI struct {
+	Path    string
+	Records []SDIRecord
+	Err     error // set when the tablespace could not be decoded, such as ErrUnsupportedTablespace
+}
+
+// ExtractSDI reads an archive from r and returns the SDI records of every tablespace member that carries SDI,
+// sorted by path. Tablespaces that can not be decoded are returned with Err set rather than failing the archive.
+func ExtractSDI(r *Reader) ([]TablespaceSDI, error) {
+	scanners := make(map[string]*sdiScanner)
+	var results []TablespaceSDI
+
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		path := string(chunk.Path)
+		if !isTablespace(path) {
+			continue
+		}
+
+		s, ok := scanners[path]
+		if !ok {
+			s = new(sdiScanner)
+			scanners[path] = s
+		}
+
+		if chunk.Type == ChunkTypeEOF {
+			delete(scanners, path)
+
+			records, err := s.Records()
+			if err != nil || len(records) > 0 {
+				results = append(results, TablespaceSDI{Path: path, Records: records, Err: err})
+			}
+			continue
+		}
+
+		if s.err == nil && chunk.PayOffset != uint64(s.offset) {
+			s.err = fmt.Errorf("chunk at offset %d is out of order", chunk.PayOffset)
+		}
+		if _, err = io.Copy(s, chunk); err != nil {
+			return nil, err
+		}
+	}
+
+	for path := range scanners {
+		return nil, fmt.Errorf("%s: missing EOF chunk", path)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+
+	return results, nil
+}
+
+// sdiScanner consumes a tablespace sequentially and retains the pages belonging to the SDI index
+type sdiScanner struct {
+	info   *TablespaceInfo
+	buf    []byte
+	offset int64
+	pageNo uint32
+	pages  map[uint32][]byte
+	err    error
+}
+
+func (s *sdiScanner) Write(p []byte) (int, error) {
+	written := len(p)
+	s.offset += int64(written)
+
+	if s.err != nil {
+		// Keep consuming so the archive can be read to its end, the error is reported by Records
+		return written, nil
+	}
+
+	for len(p) > 0 {
+		if s.info == nil {
+			n := tablespaceHeaderSize - len(s.buf)
+			if n > len(p) {
+				n = len(p)
+			}
+			s.buf = append(s.buf, p[:n]...)
+			p = p[n:]
+
+			if len(s.buf) < tablespaceHeaderSize {
+				break
+			}
+			if s.info, s.err = ParseTablespaceHeader(s.buf); s.err != nil {
+				break
+			}
+			if s.info.Compressed() || s.info.Encrypted {
+				s.err = ErrUnsupportedTablespace
+				break
+			}
+			s.pages = make(map[uint32][]byte)
+			continue
+		}
+
+		n := s.info.PageSize - len(s.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		s.buf = append(s.buf, p[:n]...)
+		p = p[n:]
+
+		if len(s.buf) < s.info.PageSize {
+			break
+		}
+
+		switch binary.BigEndian.Uint16(s.buf[filPageType:]) {
+		case filPageTypeSDI, filPageTypeSDIBlob:
+			s.pages[s.pageNo] = append([]byte(nil), s.buf...)
+		case filPageTypeSDIZBlob:
+			s.err = ErrUnsupportedTablespace
+		}
+		s.buf = s.buf[:0]
+		s.pageNo++
+	}
+
+	return written, nil
+}
+
+// Records decodes the SDI records from the retained SDI leaf pages, ordered by type and id
+func (s *sdiScanner) Records() ([]SDIRecord, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.info == nil {
+		return nil, errors.New("tablespace is shorter than its header")
+	}
+	if !s.info.SDI {
+		return nil, nil
+	}
+
+	var records []SDIRecord
+	for _, page := range s.pages {
+		if binary.BigEndian.Uint16(page[filPageType:]) != filPageTypeSDI || binary.BigEndian.Uint16(page[pageLevel:]) != 0 {
+			continue
+		}
+
+		recs, err := s.pageRecords(page)
+		if err != nil {
+			return nil, fmt.Errorf("sdi page %d: %v", binary.BigEndian.Uint32(page[filPageOffset:]), err)
+		}
+		records = append(records, recs...)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Type != records[j].Type {
+			return records[i].Type < records[j].Type
+		}
+		return records[i].ID < records[j].ID
+	})
+
+	return records, nil
+}
+
+// pageRecords walks the record list of an SDI leaf page from the infimum to the supremum
+func (s *sdiScanner) pageRecords(page []byte) ([]SDIRecord, error) {
+	var records []SDIRecord
+	pageSize := len(page)
+
+	rec := pageNewInfimum
+	for i := 0; ; i++ {
+		if i > pageSize/recNewExtraBytes {
+			return nil, errors.New("record list does not terminate")
+		}
+
+		rec = (rec + int(binary.BigEndian.Uint16(page[rec-recNext:]))) % pageSize
+		if rec == pageNewSupremum {
+			break
+		}
+		if rec < pageNewSupremum || rec+sdiRecData > pageSize {
+			return nil, errors.New("record offset out of bounds")
+		}
+
+		if page[rec-3]&recStatusMask != recStatusOrdinary || page[rec-recNewExtraBytes]&recInfoDeleted != 0 {
+			continue
+		}
+
+		// The length of the data column is stored in one or two bytes before the record header
+		length := int(page[rec-recNewExtraBytes-1])
+		external := false
+		if length&0x80 != 0 {
+			length = (length<<8 | int(page[rec-recNewExtraBytes-2])) & 0x7fff
+			external = length&0x4000 != 0
+			length &= 0x3fff
+		}
+		if rec+sdiRecData+length > pageSize {
+			return nil, errors.New("record data out of bounds")
+		}
+
+		record := SDIRecord{
+			Type: binary.BigEndian.Uint32(page[rec+sdiRecType:]),
+			ID:   binary.BigEndian.Uint64(page[rec+sdiRecID:]),
+		}
+		uncompLen := binary.BigEndian.Uint32(page[rec+sdiRecUncompLen:])
+		compLen := binary.BigEndian.Uint32(page[rec+sdiRecCompLen:])
+		if uncompLen > sdiMaxRecordSize || compLen > sdiMaxRecordSize {
+			return nil, errors.New("record length out of bounds")
+		}
+
+		data := page[rec+sdiRecData : rec+sdiRecData+length]
+		if external {
+			var err error
+			if data, err = s.externalData(data, int(compLen)); err != nil {
+				return nil, err
+			}
+		}
+		if len(data) != int(compLen) {
+			return nil, fmt.Errorf("sdi record %d has %d compressed bytes, expected %d", record.ID, len(data), compLen)
+		}
+
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if record.Data, err = ioutil.ReadAll(zr); err != nil {
+			return nil, err
+		}
+		if len(record.Data) != int(uncompLen) {
+			return nil, fmt.Errorf("sdi record %d has %d uncompressed bytes, expected %d", record.ID, len(record.Data), uncompLen)
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// externalData reassembles a column stored off-page: the local prefix is followed by a field reference
+// pointing to a chain of SDI BLOB pages
+func (s *sdiScanner) externalData(local []byte, length int) ([]byte, error) {
+	if len(local) < btrExternFieldRefSize {
+		return nil, errors.New("external field reference is truncated")
+	}
+
+	ref := local[len(local)-btrExternFieldRefSize:]
+	data := append([]byte(nil), local[:len(local)-btrExternFieldRefSize]...)
+
+	pageNo := binary.BigEndian.Uint32(ref[4:])
+	for pageNo != filNull && len(data) < length {
+		page, ok := s.pages[pageNo]
+		if !ok || binary.BigEndian.Uint16(page[filPageType:]) != filPageTypeSDIBlob {
+			return nil, fmt.Errorf("sdi blob page %d not found", pageNo)
+		}
+
+		partLen := int(binary.BigEndian.Uint32(page[filPageData+btrBlobHdrPartLen:]))
+		start := filPageData + btrBlobHdrSize
+		if start+partLen > len(page) {
+			return nil, fmt.Errorf("sdi blob page %d is corrupt", pageNo)
+		}
+
+		data = append(data, page[start:start+partLen]...)
+		pageNo = binary.BigEndian.Uint32(page[filPageData+btrBlobHdrNextPageNo:])
+	}
+
+	return data, nil
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sdiTestTable = `{"dd_object_type":"Table","dd_object":{"name":"t1","schema_ref":"test",
+"columns":[{"name":"id","column_type_utf8":"int","is_nullable":false,"hidden":1},
+{"name":"DB_TRX_ID","column_type_utf8":"","is_nullable":false,"hidden":2}],
+"indexes":[{"name":"PRIMARY","type":1,"hidden":false,"elements":[{"column_opx":0,"hidden":false},{"column_opx":1,"hidden":true}]}]}}`
+
+const sdiTestPageSize = 4096
+
+func compressSDI(t *testing.T, data string) []byte {
+	buffer := new(bytes.Buffer)
+	zw := zlib.NewWriter(buffer)
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buffer.Bytes()
+}
+
+// putSDIRecord writes an SDI record with origin rec pointing to next, local holds the in-page column data
+func putSDIRecord(page []byte, rec, next int, typ uint32, id uint64, uncompLen, compLen int, local []byte, external bool) {
+	length := len(local)
+	if external {
+		length |= 0x4000
+	}
+	page[rec-recNewExtraBytes-1] = byte(0x80 | length>>8)
+	page[rec-recNewExtraBytes-2] = byte(length)
+	binary.BigEndian.PutUint16(page[rec-recNext:], uint16((next-rec+sdiTestPageSize)%sdiTestPageSize))
+
+	binary.BigEndian.PutUint32(page[rec+sdiRecType:], typ)
+	binary.BigEndian.PutUint64(page[rec+sdiRecID:], id)
+	binary.BigEndian.PutUint32(page[rec+sdiRecUncompLen:], uint32(uncompLen))
+	binary.BigEndian.PutUint32(page[rec+sdiRecCompLen:], uint32(compLen))
+	copy(page[rec+sdiRecData:], local)
+}
+
+func buildSDITablespace(t *testing.T) []byte {
+	space := make([]byte, 3*sdiTestPageSize)
+
+	header := space[:sdiTestPageSize]
+	binary.BigEndian.PutUint32(header[filPageSpaceID:], 5)
+	binary.BigEndian.PutUint32(header[fspSpaceID:], 5)
+	binary.BigEndian.PutUint32(header[fspSpaceFlags:], 3<<fspFlagsPosPageSSize|1<<fspFlagsPosSDI)
+
+	table := compressSDI(t, sdiTestTable)
+	tablespace := compressSDI(t, `{"dd_object_type":"Tablespace"}`)
+
+	leaf := space[sdiTestPageSize : 2*sdiTestPageSize]
+	binary.BigEndian.PutUint32(leaf[filPageOffset:], 1)
+	binary.BigEndian.PutUint16(leaf[filPageType:], filPageTypeSDI)
+	binary.BigEndian.PutUint16(leaf[pageNewInfimum-recNext:], 200-pageNewInfimum)
+
+	// The table record is stored in the page, the tablespace record in a BLOB page
+	putSDIRecord(leaf, 200, 1000, SDITypeTable, 10, len(sdiTestTable), len(table), table, false)
+	ref := make([]byte, btrExternFieldRefSize)
+	binary.BigEndian.PutUint32(ref[4:], 2)
+	putSDIRecord(leaf, 1000, pageNewSupremum, SDITypeTablespace, 5, 31, len(tablespace), ref, true)
+
+	blob := space[2*sdiTestPageSize:]
+	binary.BigEndian.PutUint16(blob[filPageType:], filPageTypeSDIBlob)
+	binary.BigEndian.PutUint32(blob[filPageData+btrBlobHdrPartLen:], uint32(len(tablespace)))
+	binary.BigEndian.PutUint32(blob[filPageData+btrBlobHdrNextPageNo:], filNull)
+	copy(blob[filPageData+btrBlobHdrSize:], tablespace)
+
+	return space
+}
+
+func TestReadSDI(t *testing.T) {
+	records, err := ReadSDI(bytes.NewReader(buildSDITablespace(t)))
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	assert.Equal(t, uint32(SDITypeTable), records[0].Type)
+	assert.Equal(t, uint64(10), records[0].ID)
+	assert.JSONEq(t, sdiTestTable, string(records[0].Data))
+
+	assert.Equal(t, uint32(SDITypeTablespace), records[1].Type)
+	assert.JSONEq(t, `{"dd_object_type":"Tablespace"}`, string(records[1].Data))
+
+	table, err := records[0].Table()
+	require.NoError(t, err)
+	assert.Equal(t, &SDITable{
+		Schema:  "test",
+		Name:    "t1",
+		Columns: []SDIColumn{{Name: "id", Type: "int"}},
+		Indexes: []SDIIndex{{Name: "PRIMARY", Type: "PRIMARY", Columns: []string{"id"}}},
+	}, table)
+
+	_, err = records[1].Table()
+	assert.Error(t, err)
+}
+
+func TestExtractSDI(t *testing.T) {
+	encrypted := make([]byte, sdiTestPageSize)
+	binary.BigEndian.PutUint32(encrypted[fspSpaceFlags:], 1<<fspFlagsPosEncrypted|1<<fspFlagsPosSDI)
+
+	archive := buildArchive(t, testFile{"test/t1.ibd", [][]byte{buildSDITablespace(t)}}, testFile{"test/secret.ibd", [][]byte{encrypted}})
+
+	results, err := ExtractSDI(NewReader(bytes.NewReader(archive)))
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	assert.Equal(t, "test/secret.ibd", results[0].Path)
+	assert.Equal(t, ErrUnsupportedTablespace, results[0].Err)
+
+	assert.Equal(t, "test/t1.ibd", results[1].Path)
+	assert.NoError(t, results[1].Err)
+	assert.Len(t, results[1].Records, 2)
+}
@@ -0,0 +1,458 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink receives the files reassembled by an Extractor
+type Sink interface {
+	// Create starts the file stored as path. resume is set when an earlier extraction recorded in a journal
+	// wrote part of the file, in which case content already written should be kept.
+	Create(path string, resume bool) (SinkFile, error)
+}
+
+// SinkFile is a file being written to a Sink. WriteAt is called concurrently by the extraction workers with
+// payloads in any order. Once no writes are pending exactly one of Finalize or Abort is called: Finalize when the
+// EOF chunk of the file is read, Abort when extraction ends without it.
+type SinkFile interface {
+	io.WriterAt
+	Finalize() error
+	Abort() error
+}
+
+// syncer is implemented by sink files that can flush their content to stable storage
+type syncer interface {
+	Sync() error
+}
+
+// NewDirSink returns a Sink writing files beneath dir, refusing paths that would escape it. Aborted files are
+// left in place with the content written so far, so a journaled extraction can resume them.
+func NewDirSink(dir string) Sink {
+	return dirSink{dir: dir}
+}
+
+type dirSink struct {
+	dir string
+}
+
+type dirFile struct {
+	*os.File
+}
+
+func (s dirSink) Create(path string, resume bool) (SinkFile, error) {
+	name := filepath.Join(s.dir, filepath.FromSlash(path))
+	if rel, err := filepath.Rel(s.dir, name); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s: path escapes the extraction directory", path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return nil, err
+	}
+
+	flags := os.O_CREATE | os.O_TRUNC | os.O_WRONLY
+	if resume {
+		flags &^= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(name, flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	return dirFile{file}, nil
+}
+
+func (f dirFile) Finalize() error {
+	return f.Close()
+}
+
+func (f dirFile) Abort() error {
+	return f.Close()
+}
+
+// MemorySink holds extracted files in memory, it is intended for tests and small archives
+type MemorySink struct {
+	mutex sync.Mutex
+	files map[string][]byte
+}
+
+type memoryFile struct {
+	sink  *MemorySink
+	path  string
+	mutex sync.Mutex
+	data  []byte
+}
+
+// NewMemorySink returns an empty MemorySink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{files: make(map[string][]byte)}
+}
+
+// Create starts a file, when resuming the content of an earlier finalized file of the same path is kept
+func (s *MemorySink) Create(path string, resume bool) (SinkFile, error) {
+	f := &memoryFile{sink: s, path: path}
+	if resume {
+		s.mutex.Lock()
+		f.data = append([]byte(nil), s.files[path]...)
+		s.mutex.Unlock()
+	}
+	return f, nil
+}
+
+// Files returns the content of every finalized file by path
+func (s *MemorySink) Files() map[string][]byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	files := make(map[string][]byte, len(s.files))
+	for path, data := range s.files {
+		files[path] = data
+	}
+	return files
+}
+
+func (f *memoryFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	return copy(f.data[off:], p), nil
+}
+
+func (f *memoryFile) Finalize() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.sink.mutex.Lock()
+	f.sink.files[f.path] = f.data
+	f.sink.mutex.Unlock()
+	return nil
+}
+
+func (f *memoryFile) Abort() error {
+	return nil
+}
+
+// Discard is a Sink that drops the content of every file, extracting to it checks an archive's checksums
+// without writing anything
+var Discard Sink = discardSink{}
+
+type discardSink struct{}
+
+func (discardSink) Create(string, bool) (SinkFile, error) {
+	return discardSink{}, nil
+}
+
+func (discardSink) WriteAt(p []byte, _ int64) (int, error) {
+	return len(p), nil
+}
+
+func (discardSink) Finalize() error {
+	return nil
+}
+
+func (discardSink) Abort() error {
+	return nil
+}
+
+// TarSink writes extracted files as the entries of a tar stream. An entry is written once its file is finalized,
+// until then the file is reassembled in memory or in a spill file as ToTar does. Aborted files are dropped.
+type TarSink struct {
+	opts    TarOptions
+	mutex   sync.Mutex
+	writer  *tar.Writer
+	modTime time.Time
+}
+
+type tarFile struct {
+	sink   *TarSink
+	path   string
+	mutex  sync.Mutex
+	buffer *spillBuffer
+}
+
+// NewTarSink returns a TarSink writing to w, Close must be called to write the end of the tar stream
+func NewTarSink(w io.Writer, opts TarOptions) *TarSink {
+	if opts.SpillThreshold <= 0 {
+		opts.SpillThreshold = MinimumChunkSize
+	}
+	return &TarSink{opts: opts, writer: tar.NewWriter(w), modTime: time.Now()}
+}
+
+// Create starts a file, resuming is not supported as tar entries can not be amended
+func (s *TarSink) Create(path string, resume bool) (SinkFile, error) {
+	if resume {
+		return nil, fmt.Errorf("%s: a tar entry can not be resumed", path)
+	}
+	return &tarFile{sink: s, path: path, buffer: &spillBuffer{dir: s.opts.SpillDir, threshold: s.opts.SpillThreshold}}, nil
+}
+
+// Close writes the end of the tar stream, it does not close the underlying writer
+func (s *TarSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.writer.Close()
+}
+
+func (f *tarFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.buffer.copyAt(bytes.NewReader(p), off, int64(len(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *tarFile) Finalize() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.buffer.Close()
+
+	f.sink.mutex.Lock()
+	defer f.sink.mutex.Unlock()
+	return writeTarEntry(f.sink.writer, f.path, f.buffer, f.sink.modTime)
+}
+
+func (f *tarFile) Abort() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.buffer.Close()
+}
+
+// CommandSink runs a command for each extracted file and writes the content of the file to its standard input.
+// A file whose size is known from Sizes is streamed to a command started as soon as the file is created, with
+// payloads arriving ahead of their position held as Cat holds them. Other files, and files created while
+// Parallel commands are already running, are reassembled as ToTar does and written to a command started once
+// their EOF chunk is read. Aborting a file kills its command.
+type CommandSink struct {
+	Command      func(path string, size int64) *exec.Cmd // returns the command to run for the file stored as path
+	Sizes        map[string]int64                        // sizes of the files when known ahead, such as from BuildIndex
+	Parallel     int                                     // maximum number of commands running at once, unlimited when 0
+	SpillDir     string                                  // directory for spill files, the system temporary directory when empty
+	ReorderLimit int64                                   // bytes held in memory per file before spilling, defaults to MinimumChunkSize
+
+	// ExitStatus is called with the error of a command that fails or stops reading its input early and
+	// returns the error failing the extraction, nil accepts the failure. When not set every failure fails
+	// the extraction. ExitStatus and Command may be called concurrently.
+	ExitStatus func(path string, err error) error
+
+	once  sync.Once
+	slots chan struct{}
+}
+
+type commandFile struct {
+	sink   *CommandSink
+	path   string
+	mutex  sync.Mutex
+	size   int64          // size the command was started with
+	cmd    *exec.Cmd      // nil until the command is started
+	input  *commandInput  // standard input of the command
+	stream *reorderBuffer // writes payloads to a streaming command in file order
+	buffer *spillBuffer   // holds the content of a file until its command is started
+}
+
+// commandInput writes to the standard input of a command. Once the command stops reading, further content is
+// discarded so the command can be waited for and its exit status decides the outcome.
+type commandInput struct {
+	io.WriteCloser
+	err error
+}
+
+func (c *commandInput) Write(p []byte) (int, error) {
+	if c.err == nil {
+		_, c.err = c.WriteCloser.Write(p)
+	}
+	return len(p), nil
+}
+
+// Create starts the command for path when its size is known and fewer than Parallel commands are running, and
+// buffers the file otherwise. Resuming is not supported as the content can not be written again.
+func (s *CommandSink) Create(path string, resume bool) (SinkFile, error) {
+	if resume {
+		return nil, fmt.Errorf("%s: a command can not be resumed", path)
+	}
+
+	f := &commandFile{sink: s, path: path}
+
+	if size, ok := s.Sizes[path]; ok && s.acquire(false) {
+		if err := f.start(size); err != nil {
+			s.release()
+			return nil, err
+		}
+
+		limit := s.ReorderLimit
+		if limit <= 0 {
+			limit = MinimumChunkSize
+		}
+		f.stream = &reorderBuffer{writer: f.input, limit: limit, spill: &spillBuffer{dir: s.SpillDir}}
+		return f, nil
+	}
+
+	f.buffer = &spillBuffer{dir: s.SpillDir, threshold: s.ReorderLimit}
+	if f.buffer.threshold <= 0 {
+		f.buffer.threshold = MinimumChunkSize
+	}
+	return f, nil
+}
+
+// acquire takes a command slot, waiting for one to be released when wait is set
+func (s *CommandSink) acquire(wait bool) bool {
+	if s.Parallel <= 0 {
+		return true
+	}
+	s.once.Do(func() { s.slots = make(chan struct{}, s.Parallel) })
+
+	if wait {
+		s.slots <- struct{}{}
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *CommandSink) release() {
+	if s.Parallel > 0 {
+		<-s.slots
+	}
+}
+
+// start runs the command of the file, the caller holds a command slot
+func (f *commandFile) start(size int64) error {
+	cmd := f.sink.Command(f.path, size)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("%s: %v", f.path, err)
+	}
+
+	f.size = size
+	f.cmd = cmd
+	f.input = &commandInput{WriteCloser: stdin}
+	return nil
+}
+
+// wait closes the input of the command and waits for it to exit, releasing its slot
+func (f *commandFile) wait() error {
+	f.input.Close()
+	err := f.cmd.Wait()
+	f.sink.release()
+
+	if err == nil {
+		err = f.input.err
+	}
+	if err == nil {
+		return nil
+	}
+	if f.sink.ExitStatus != nil {
+		return f.sink.ExitStatus(f.path, err)
+	}
+	return fmt.Errorf("%s: %v", f.path, err)
+}
+
+func (f *commandFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.stream == nil {
+		if err := f.buffer.copyAt(bytes.NewReader(p), off, int64(len(p))); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if err := f.stream.insert(bytes.NewReader(p), off, int64(len(p))); err != nil {
+		return 0, fmt.Errorf("%s: %v", f.path, err)
+	}
+	if err := f.stream.drain(); err != nil {
+		return 0, fmt.Errorf("%s: %v", f.path, err)
+	}
+	return len(p), nil
+}
+
+func (f *commandFile) Finalize() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.stream != nil {
+		defer f.stream.spill.Close()
+		if len(f.stream.ranges) > 0 {
+			f.kill()
+			return fmt.Errorf("%s: missing data at offset %d", f.path, f.stream.next)
+		}
+		if f.stream.next != f.size {
+			// The command was told the size of the file, a different amount of content is not the file it expects
+			f.kill()
+			return fmt.Errorf("%s: extracted %d bytes of a file of %d bytes", f.path, f.stream.next, f.size)
+		}
+		return f.wait()
+	}
+
+	defer f.buffer.Close()
+	f.sink.acquire(true)
+	if err := f.start(f.buffer.size); err != nil {
+		f.sink.release()
+		return err
+	}
+	if _, err := io.Copy(f.input, f.buffer.Reader()); err != nil {
+		f.kill()
+		return fmt.Errorf("%s: %v", f.path, err)
+	}
+	return f.wait()
+}
+
+func (f *commandFile) Abort() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.stream == nil {
+		return f.buffer.Close()
+	}
+	defer f.stream.spill.Close()
+	f.kill()
+	return nil
+}
+
+// kill stops the command of a streamed file
+func (f *commandFile) kill() {
+	f.input.Close()
+	f.cmd.Process.Kill()
+	f.cmd.Wait()
+	f.sink.release()
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinks(t *testing.T) {
+	block := func(b byte) []byte { return bytes.Repeat([]byte{b}, 100) }
+	archive := buildArchive(t, testFile{"ibdata1", [][]byte{block(1), block(2), block(3)}}, testFile{"db/t1.ibd", [][]byte{block(4)}})
+
+	memory := NewMemorySink()
+	require.NoError(t, (&Extractor{Sink: memory, Parallel: 4}).Extract(NewReader(bytes.NewReader(archive))))
+	assert.Equal(t, map[string][]byte{"ibdata1": bytes.Join([][]byte{block(1), block(2), block(3)}, nil), "db/t1.ibd": block(4)}, memory.Files())
+
+	// Discarding still verifies checksums
+	require.NoError(t, (&Extractor{Sink: Discard}).Extract(NewReader(bytes.NewReader(archive))))
+	corrupt := append([]byte(nil), archive...)
+	corrupt[len(corrupt)-60] ^= 0xff
+	err := (&Extractor{Sink: Discard}).Extract(NewReader(bytes.NewReader(corrupt)))
+	assert.True(t, errors.Is(err, ErrChecksum))
+
+	var out bytes.Buffer
+	sink := NewTarSink(&out, TarOptions{SpillThreshold: 150})
+	require.NoError(t, (&Extractor{Sink: sink, Parallel: 4}).Extract(NewReader(bytes.NewReader(archive))))
+	require.NoError(t, sink.Close())
+	tr := tar.NewReader(&out)
+	entries := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		entries[header.Name], err = ioutil.ReadAll(tr)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, memory.Files(), entries)
+
+	assert.Error(t, (&Extractor{Sink: memory, Durable: true}).Extract(NewReader(bytes.NewReader(archive))))
+}
+
+func TestCommandSink(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	dir, err := ioutil.TempDir("", "command")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	block := func(b byte) []byte { return bytes.Repeat([]byte{b}, 100) }
+	content := [][]byte{block(1), block(2), block(3), block(4)}
+	archive := buildArchive(t, testFile{"ibdata1", content})
+
+	want := bytes.Join(content, nil)
+
+	// A known size is streamed, an unknown size or a command over the limit is buffered until the EOF chunk
+	for _, sizes := range []map[string]int64{{"ibdata1": 400}, nil} {
+		for _, parallel := range []int{0, 1} {
+			out := filepath.Join(dir, "ibdata1")
+			os.Remove(out)
+			sink := &CommandSink{
+				Command: func(path string, size int64) *exec.Cmd {
+					assert.Equal(t, int64(len(want)), size)
+					return exec.Command("sh", "-c", `cat > "$0"`, filepath.Join(dir, path))
+				},
+				Sizes:        sizes,
+				Parallel:     parallel,
+				ReorderLimit: 100,
+			}
+			require.NoError(t, (&Extractor{Sink: sink, Parallel: 4}).Extract(NewReader(bytes.NewReader(archive))))
+			data, err := ioutil.ReadFile(out)
+			require.NoError(t, err)
+			assert.Equal(t, want, data)
+		}
+	}
+
+	// A streamed file that ends short of the size its command was started with is not extracted
+	short := &CommandSink{
+		Command: func(path string, size int64) *exec.Cmd { return exec.Command("sh", "-c", "cat > /dev/null") },
+		Sizes:   map[string]int64{"ibdata1": 500},
+	}
+	assert.Error(t, (&Extractor{Sink: short}).Extract(NewReader(bytes.NewReader(archive))))
+
+	failing := &CommandSink{Command: func(string, int64) *exec.Cmd { return exec.Command("sh", "-c", "exit 3") }}
+	assert.Error(t, (&Extractor{Sink: failing}).Extract(NewReader(bytes.NewReader(archive))))
+
+	var failed []string
+	failing.ExitStatus = func(path string, err error) error {
+		failed = append(failed, path)
+		return nil
+	}
+	assert.NoError(t, (&Extractor{Sink: failing}).Extract(NewReader(bytes.NewReader(archive))))
+	assert.Equal(t, []string{"ibdata1"}, failed)
+}
+
+func TestCommandSinkMissingEOF(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	dir, err := ioutil.TempDir("", "command")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a holds the only command slot and never receives its EOF chunk, b waits for the slot to be released
+	chunks := splitChunks(t, buildArchive(t, testFile{"a", [][]byte{[]byte("unfinished")}}, testFile{"b", [][]byte{[]byte("complete")}}))
+	archive := bytes.Join([][]byte{chunks[0], chunks[2], chunks[3]}, nil)
+
+	sink := &CommandSink{
+		Command: func(path string, size int64) *exec.Cmd {
+			return exec.Command("sh", "-c", `cat > "$0"`, filepath.Join(dir, path))
+		},
+		Sizes:    map[string]int64{"a": 100, "b": 8},
+		Parallel: 1,
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- (&Extractor{Sink: sink}).Extract(NewReader(bytes.NewReader(archive))) }()
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("extraction did not finish")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "b"))
+	require.NoError(t, err)
+	assert.Equal(t, "complete", string(data))
+}
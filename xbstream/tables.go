@@ -0,0 +1,184 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Suffixes added to a member by xtrabackup when it is compressed, encrypted or taken as an incremental
+var transformSuffixes = []string{".qp", ".zst", ".lz4", ".xbcrypt", DeltaSuffix, DeltaMetaSuffix}
+
+// Extensions of the files that belong to a table in the MySQL data directory
+var tableExtensions = map[string]bool{
+	".ibd": true, ".cfg": true, ".cfp": true, ".frm": true, ".sdi": true, ".isl": true, ".exp": true,
+	".par": true, ".TRG": true, ".MYD": true, ".MYI": true, ".MRG": true, ".ARZ": true, ".ARM": true,
+	".CSV": true, ".CSM": true,
+}
+
+var (
+	partitionSuffix = regexp.MustCompile(`#[Pp]#.*$`)
+	sdiSuffix       = regexp.MustCompile(`_[0-9]+$`)
+)
+
+// TableFilter matches archive members against a set of table names given as schema.table. Patterns are globs
+// unless prefixed by '~', in which case the remainder is a regular expression matched against schema.table.
+// Members that are not part of any schema directory, such as the system tablespace, undo tablespaces and the
+// xtrabackup metadata files, always match since they are required to prepare and export the tables.
+type TableFilter struct {
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+// NewTableFilter creates a TableFilter matching any of patterns
+func NewTableFilter(patterns []string) (*TableFilter, error) {
+	f := new(TableFilter)
+
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+
+		if strings.HasPrefix(pattern, "~") {
+			re, err := regexp.Compile(pattern[1:])
+			if err != nil {
+				return nil, err
+			}
+			f.regexps = append(f.regexps, re)
+			continue
+		}
+
+		if !strings.Contains(pattern, ".") {
+			return nil, fmt.Errorf("table pattern %q is not of the form schema.table", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("table pattern %q: %v", pattern, err)
+		}
+		f.globs = append(f.globs, pattern)
+	}
+
+	if len(f.globs) == 0 && len(f.regexps) == 0 {
+		return nil, errors.New("no table patterns given")
+	}
+
+	return f, nil
+}
+
+// Match reports whether the member at p belongs to a matching table or is required for every table
+func (f *TableFilter) Match(p string) bool {
+	dir, file := path.Split(p)
+	if dir == "" {
+		return true
+	}
+
+	schema := DecodeFilename(strings.TrimSuffix(dir, "/"))
+	if file == "db.opt" {
+		return f.matchSchema(schema)
+	}
+
+	table, ok := TableName(file)
+	if !ok {
+		return false
+	}
+
+	return f.match(schema + "." + table)
+}
+
+func (f *TableFilter) match(name string) bool {
+	for _, glob := range f.globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	for _, re := range f.regexps {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSchema reports whether any glob could match a table within schema. Regular expressions can not be
+// inspected this way so they match every schema.
+func (f *TableFilter) matchSchema(schema string) bool {
+	if len(f.regexps) > 0 {
+		return true
+	}
+	for _, glob := range f.globs {
+		if ok, _ := path.Match(glob[:strings.LastIndex(glob, ".")], schema); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// TableName returns the decoded name of the table a data directory file belongs to. Compression, encryption
+// and incremental suffixes, partition and subpartition names, and the id of .sdi files are removed.
+func TableName(file string) (string, bool) {
+	for stripped := true; stripped; {
+		stripped = false
+		for _, suffix := range transformSuffixes {
+			if strings.HasSuffix(file, suffix) {
+				file = strings.TrimSuffix(file, suffix)
+				stripped = true
+			}
+		}
+	}
+
+	ext := path.Ext(file)
+	if !tableExtensions[ext] {
+		return "", false
+	}
+	name := strings.TrimSuffix(file, ext)
+
+	if ext == ".sdi" {
+		name = sdiSuffix.ReplaceAllString(name, "")
+	}
+	name = partitionSuffix.ReplaceAllString(name, "")
+
+	return DecodeFilename(name), name != ""
+}
+
+// DecodeFilename reverses the MySQL filename encoding, where characters that are not safe in file names are
+// stored as '@' followed by four hexadecimal digits of their code point
+func DecodeFilename(name string) string {
+	if !strings.Contains(name, "@") {
+		return name
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '@' && i+5 <= len(name) {
+			if r, err := strconv.ParseUint(name[i+1:i+5], 16, 32); err == nil {
+				b.WriteRune(rune(r))
+				i += 4
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+
+	return b.String()
+}
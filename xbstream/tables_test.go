@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeFilename(t *testing.T) {
+	assert.Equal(t, "my-table", DecodeFilename("my@002dtable"))
+	assert.Equal(t, "a.b", DecodeFilename("a@002eb"))
+	assert.Equal(t, "plain", DecodeFilename("plain"))
+	assert.Equal(t, "bad@zz", DecodeFilename("bad@zz"))
+}
+
+func TestTableFilter(t *testing.T) {
+	filter, err := NewTableFilter([]string{"sales.order*", "~^my-db\\.t[0-9]$"})
+	require.NoError(t, err)
+
+	for p, expected := range map[string]bool{
+		"sales/orders.ibd":             true,
+		"sales/orders.cfg":             true,
+		"sales/orders.frm.qp":          true,
+		"sales/orders.ibd.qp.xbcrypt":  true,
+		"sales/orders#P#p2019.ibd":     true,
+		"sales/orders#p#p0#sp#sp1.ibd": true,
+		"sales/orders_1234.sdi":        true,
+		"sales/orders.ibd.delta":       true,
+		"sales/customers.ibd":          false,
+		"sales/db.opt":                 true,
+		"my@002ddb/t1.ibd":             true,
+		"my@002ddb/t10.ibd":            false,
+		"ibdata1":                      true,
+		"xtrabackup_checkpoints":       true,
+		"undo_001":                     true,
+		"sales/orders.unknown":         false,
+	} {
+		assert.Equal(t, expected, filter.Match(p), p)
+	}
+
+	globs, err := NewTableFilter([]string{"sales.order*"})
+	require.NoError(t, err)
+	assert.True(t, globs.Match("sales/db.opt"))
+	assert.False(t, globs.Match("hr/db.opt"), "schema options are only needed for matching schemas")
+
+	_, err = NewTableFilter([]string{"sales"})
+	assert.Error(t, err)
+	_, err = NewTableFilter([]string{"~("})
+	assert.Error(t, err)
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// tablespaceHeaderSize is the prefix of page 0 needed to decode the FIL and FSP headers
+const tablespaceHeaderSize = fspSpaceFlags + 4
+
+// TablespaceInfo describes an InnoDB tablespace stored within an archive
+type TablespaceInfo struct {
+	Path      string
+	SpaceID   uint32
+	Flags     uint32 // FSP_SPACE_FLAGS
+	PageSize  int
+	ZipSize   int    // compressed page size, 0 when the tablespace is not compressed
+	Encrypted bool   // pages are encrypted and restoring requires the keyring
+	SDI       bool   // tablespace contains serialized dictionary information
+	Pages     uint32 // FSP_SIZE, tablespace size in pages
+	Size      int64  // size of the member in bytes
+}
+
+// Compressed reports whether the tablespace uses compressed pages
+func (t *TablespaceInfo) Compressed() bool {
+	return t.ZipSize != 0
+}
+
+// ParseTablespaceHeader decodes the FIL and FSP headers found at the start of page 0 of a tablespace
+func ParseTablespaceHeader(page []byte) (*TablespaceInfo, error) {
+	if len(page) < tablespaceHeaderSize {
+		return nil, errors.New("tablespace is shorter than its header")
+	}
+
+	info := &TablespaceInfo{
+		SpaceID: binary.BigEndian.Uint32(page[fspSpaceID:]),
+		Flags:   binary.BigEndian.Uint32(page[fspSpaceFlags:]),
+		Pages:   binary.BigEndian.Uint32(page[fspSize:]),
+	}
+
+	if id := pageSpaceID(page); id != info.SpaceID {
+		return nil, fmt.Errorf("page space id %d does not match header space id %d", id, info.SpaceID)
+	}
+
+	info.PageSize = univPageSizeOrig
+	if ssize := (info.Flags >> fspFlagsPosPageSSize) & 0xF; ssize != 0 {
+		info.PageSize = 512 << ssize
+	}
+	if zssize := (info.Flags >> fspFlagsPosZipSSize) & 0xF; zssize != 0 {
+		info.ZipSize = 512 << zssize
+	}
+	info.Encrypted = info.Flags&(1<<fspFlagsPosEncrypted) != 0
+	info.SDI = info.Flags&(1<<fspFlagsPosSDI) != 0
+
+	return info, nil
+}
+
+// InventoryTablespaces reads an archive from r and returns the header information of every tablespace member,
+// sorted by path. Only the first bytes of page 0 of each tablespace are retained while reading.
+func InventoryTablespaces(r *Reader) ([]*TablespaceInfo, error) {
+	type member struct {
+		header []byte
+		size   int64
+	}
+	members := make(map[string]*member)
+
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		path := string(chunk.Path)
+		if chunk.Type != ChunkTypePayload || !isTablespace(path) {
+			continue
+		}
+
+		m, ok := members[path]
+		if !ok {
+			m = &member{header: make([]byte, tablespaceHeaderSize)}
+			members[path] = m
+		}
+
+		if end := int64(chunk.PayOffset + chunk.PayLen); end > m.size {
+			m.size = end
+		}
+
+		if chunk.PayOffset < tablespaceHeaderSize {
+			n := int(tablespaceHeaderSize - chunk.PayOffset)
+			if uint64(n) > chunk.PayLen {
+				n = int(chunk.PayLen)
+			}
+			if _, err = io.ReadFull(chunk, m.header[chunk.PayOffset:int(chunk.PayOffset)+n]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	infos := make([]*TablespaceInfo, 0, len(members))
+	for path, m := range members {
+		if m.size < tablespaceHeaderSize {
+			return nil, fmt.Errorf("%s: tablespace is shorter than its header", path)
+		}
+
+		info, err := ParseTablespaceHeader(m.header)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		info.Path = path
+		info.Size = m.size
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Path < infos[j].Path })
+
+	return infos, nil
+}
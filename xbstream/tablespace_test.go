@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInventoryTablespaces(t *testing.T) {
+	page := make([]byte, 4096)
+	binary.BigEndian.PutUint32(page[filPageSpaceID:], 12)
+	binary.BigEndian.PutUint32(page[fspSpaceID:], 12)
+	binary.BigEndian.PutUint32(page[fspSize:], 6)
+	// 4KiB pages, 2KiB compressed pages, encrypted
+	binary.BigEndian.PutUint32(page[fspSpaceFlags:], 3<<fspFlagsPosPageSSize|2<<fspFlagsPosZipSSize|1<<fspFlagsPosEncrypted)
+
+	archive := nopWriteCloser{new(bytes.Buffer)}
+	w := NewWriter(archive)
+	for path, content := range map[string][]byte{
+		"db/t1.ibd":  page,
+		"db/t1.frm":  []byte("not a tablespace"),
+		"ibdata1.qp": []byte("compressed"),
+	} {
+		f, err := w.Create(path)
+		require.NoError(t, err)
+		_, err = f.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	infos, err := InventoryTablespaces(NewReader(bytes.NewReader(archive.Bytes())))
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+
+	info := infos[0]
+	assert.Equal(t, "db/t1.ibd", info.Path)
+	assert.Equal(t, uint32(12), info.SpaceID)
+	assert.Equal(t, 4096, info.PageSize)
+	assert.Equal(t, 2048, info.ZipSize)
+	assert.True(t, info.Compressed())
+	assert.True(t, info.Encrypted)
+	assert.False(t, info.SDI)
+	assert.Equal(t, uint32(6), info.Pages)
+	assert.Equal(t, int64(4096), info.Size)
+
+	binary.BigEndian.PutUint32(page[filPageSpaceID:], 13)
+	_, err = ParseTablespaceHeader(page)
+	assert.Error(t, err, "mismatched space ids must be rejected")
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// TarOptions controls how ToTar buffers files while waiting for their EOF chunk
+type TarOptions struct {
+	SpillDir       string // directory for spill files, the system temporary directory when empty
+	SpillThreshold int64  // size above which a file is spilled to disk, defaults to MinimumChunkSize
+}
+
+// ToTar converts the archive read from r into a tar stream written to w. A tar entry requires its size up front
+// and contiguous content, so each file is reassembled until its EOF chunk is read and then written as an entry.
+// Files are held in memory until they grow beyond the spill threshold, after which they are spilled to disk.
+// Payloads are verified against their checksums before they are added to an entry.
+func ToTar(w io.Writer, r *Reader, opts TarOptions) error {
+	if opts.SpillThreshold <= 0 {
+		opts.SpillThreshold = MinimumChunkSize
+	}
+
+	tw := tar.NewWriter(w)
+	files := make(map[string]*spillBuffer)
+	modTime := time.Now()
+
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		if chunk.Type == ChunkTypeUnknown {
+			continue
+		}
+
+		p := string(chunk.Path)
+		f, ok := files[p]
+		if !ok {
+			f = &spillBuffer{dir: opts.SpillDir, threshold: opts.SpillThreshold}
+			files[p] = f
+		}
+
+		if chunk.Type == ChunkTypePayload {
+			payload, err := readPayload(chunk)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			if err = f.copyAt(bytes.NewReader(payload), int64(chunk.PayOffset), int64(len(payload))); err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+			continue
+		}
+
+		delete(files, p)
+		err = writeTarEntry(tw, p, f, modTime)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	for p := range files {
+		return fmt.Errorf("%s: missing EOF chunk", p)
+	}
+
+	return tw.Close()
+}
+
+// writeTarEntry writes the reassembled content of f as a regular file entry named path
+func writeTarEntry(tw *tar.Writer, path string, f *spillBuffer, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path,
+		Mode:     0644,
+		Size:     f.size,
+		ModTime:  modTime,
+	})
+	if err == nil {
+		_, err = io.Copy(tw, f.Reader())
+	}
+	return err
+}
+
+// FromTar reads a tar stream from r and writes each regular file it contains to w. Directories, links and
+// other special entries have no representation in xbstream and are skipped.
+func FromTar(w *Writer, r io.Reader) error {
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+
+		f, err := w.Create(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if _, err = io.Copy(f, tr); err != nil {
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+	}
+}
+
+// spillBuffer reassembles a file from chunks at arbitrary offsets, in memory until it exceeds threshold and
+// in a temporary file afterwards
+type spillBuffer struct {
+	dir       string
+	threshold int64
+	memory    []byte
+	file      *os.File
+	size      int64
+}
+
+// copyAt writes n bytes read from r at offset
+func (s *spillBuffer) copyAt(r io.Reader, offset, n int64) error {
+	end := offset + n
+	if s.file == nil && end > s.threshold {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	if s.file != nil {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		if _, err := s.file.WriteAt(buf, offset); err != nil {
+			return err
+		}
+	} else {
+		if end > int64(len(s.memory)) {
+			s.memory = append(s.memory, make([]byte, end-int64(len(s.memory)))...)
+		}
+		if _, err := io.ReadFull(r, s.memory[offset:end]); err != nil {
+			return err
+		}
+	}
+
+	if end > s.size {
+		s.size = end
+	}
+	return nil
+}
+
+func (s *spillBuffer) spill() error {
+	file, err := ioutil.TempFile(s.dir, "xbstream-spill-")
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(s.memory); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	s.file = file
+	s.memory = nil
+	return nil
+}
+
+// Reader returns the reassembled content
+func (s *spillBuffer) Reader() io.Reader {
+	return s.section(0, s.size)
+}
+
+// section returns the n bytes of content starting at offset
+func (s *spillBuffer) section(offset, n int64) io.Reader {
+	if s.file != nil {
+		return io.NewSectionReader(s.file, offset, n)
+	}
+	return bytes.NewReader(s.memory[offset : offset+n])
+}
+
+// Close releases the buffer, removing any spill file
+func (s *spillBuffer) Close() error {
+	s.memory = nil
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarRoundTrip(t *testing.T) {
+	spill, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(spill)
+
+	small := []byte("small file")
+	large := bytes.Repeat([]byte{0xAB}, 3*MinimumChunkSize/2)
+
+	// Interleave the chunks of both files so neither is contiguous in the stream
+	chunks := splitChunks(t, buildArchive(t,
+		testFile{"db/large.ibd", [][]byte{large[:MinimumChunkSize], large[MinimumChunkSize:]}},
+		testFile{"small.txt", [][]byte{small}},
+	))
+	archive := bytes.Join([][]byte{chunks[0], chunks[3], chunks[4], chunks[1], chunks[2]}, nil)
+
+	tarball := new(bytes.Buffer)
+	opts := TarOptions{SpillDir: spill, SpillThreshold: 1024}
+	require.NoError(t, ToTar(tarball, NewReader(bytes.NewReader(archive)), opts))
+
+	entries, err := ioutil.ReadDir(spill)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "spill files should be removed")
+
+	tr := tar.NewReader(bytes.NewReader(tarball.Bytes()))
+	contents := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		contents[header.Name], err = ioutil.ReadAll(tr)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, map[string][]byte{"db/large.ibd": large, "small.txt": small}, contents)
+
+	converted := nopWriteCloser{new(bytes.Buffer)}
+	require.NoError(t, FromTar(NewWriter(converted), bytes.NewReader(tarball.Bytes())))
+
+	entriesByPath := make(map[string]int64)
+	index, err := BuildIndex(NewReader(bytes.NewReader(converted.Bytes())))
+	require.NoError(t, err)
+	for _, entry := range index {
+		assert.True(t, entry.EOF)
+		entriesByPath[entry.Path] = entry.Size
+	}
+	assert.Equal(t, map[string]int64{"db/large.ibd": int64(len(large)), "small.txt": int64(len(small))}, entriesByPath)
+}
+
+func TestToTarMissingEOF(t *testing.T) {
+	chunks := splitChunks(t, buildArchive(t, testFile{"file", [][]byte{[]byte("unterminated")}}))
+
+	err := ToTar(ioutil.Discard, NewReader(bytes.NewReader(chunks[0])), TarOptions{})
+	assert.Error(t, err)
+}
+
+func TestToTarCorrupt(t *testing.T) {
+	archive := buildArchive(t, testFile{"file", [][]byte{[]byte("content")}})
+
+	corrupt := append([]byte(nil), archive...)
+	corrupt[39] ^= 0xff
+	err := ToTar(ioutil.Discard, NewReader(bytes.NewReader(corrupt)), TarOptions{})
+	assert.True(t, errors.Is(err, ErrChecksum), "%v", err)
+
+	// A corrupt payload length is refused rather than allocated
+	huge := append([]byte(nil), archive...)
+	binary.LittleEndian.PutUint64(huge[18:], 1<<62)
+	err = ToTar(ioutil.Discard, NewReader(bytes.NewReader(huge)), TarOptions{})
+	assert.True(t, errors.Is(err, ErrPayloadLength), "%v", err)
+}
@@ -21,6 +21,7 @@ package xbstream
 
 import (
 	"errors"
+	"fmt"
 	"io"
 )
 
@@ -37,6 +38,10 @@ const (
 	MaxPathLength = 512
 	// FlagChunkIgnorable indicates a chunk as ignorable
 	FlagChunkIgnorable ChunkFlag = 0x01
+
+	// maxPayloadLength is the largest payload read into memory, a larger length is assumed to be corrupt.
+	// xtrabackup never writes chunks larger than a few times MinimumChunkSize.
+	maxPayloadLength = 64 * 1024 * 1024
 )
 
 const (
@@ -52,12 +57,29 @@ var (
 	chunkMagic = []uint8("XBSTCK01")
 	// ErrStreamRead indicates an error occurred while parsing an xbstream
 	ErrStreamRead = errors.New("xbstream read error")
+	// ErrTruncated indicates the stream ended part way through a chunk
+	ErrTruncated = fmt.Errorf("%w: truncated chunk", ErrStreamRead)
+	// ErrWrongMagic indicates a chunk did not start with the xbstream chunk magic
+	ErrWrongMagic = errors.New("wrong chunk magic")
+	// ErrUnknownChunkType indicates a chunk of an unknown type that is not flagged as ignorable
+	ErrUnknownChunkType = errors.New("unknown chunk type")
+	// ErrChecksum indicates a chunk payload that does not match its checksum
+	ErrChecksum = errors.New("chunk checksum did not match")
+	// ErrPathLength indicates a chunk path longer than MaxPathLength
+	ErrPathLength = errors.New("max path length exceeded")
+	// ErrPayloadLength indicates a chunk whose payload length is too large to be genuine
+	ErrPayloadLength = errors.New("implausible payload length")
+	// ErrNotFound indicates the requested file is not stored in the archive
+	ErrNotFound = errors.New("file not found in archive")
+	// ErrCanceled indicates an operation was stopped before it completed
+	ErrCanceled = errors.New("canceled")
 )
 
 // Chunk encapsulates a ChunkHeader and provides a io.Reader interface for reading the payload described by the Header
 type Chunk struct {
 	ChunkHeader
 	io.Reader
+	Offset int64 // position of the chunk within the stream
 }
 
 // ChunkHeader contains the metadata regarding the payload that immediately follows within the archive
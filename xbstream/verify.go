@@ -0,0 +1,208 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// ProblemClass categorises a structural problem found by Verify
+type ProblemClass int
+
+const (
+	// ProblemTruncated indicates the stream ended part way through a chunk
+	ProblemTruncated ProblemClass = iota + 1
+	// ProblemCorrupt indicates a chunk header that could not be parsed, such as a wrong magic
+	ProblemCorrupt
+	// ProblemChecksum indicates a chunk whose payload does not match its CRC32
+	ProblemChecksum
+	// ProblemLayout indicates gaps or overlaps between the payload ranges of a file
+	ProblemLayout
+	// ProblemEOF indicates a file without exactly one EOF chunk, or with chunks following its EOF chunk
+	ProblemEOF
+)
+
+func (c ProblemClass) String() string {
+	switch c {
+	case ProblemTruncated:
+		return "truncated"
+	case ProblemCorrupt:
+		return "corrupt"
+	case ProblemChecksum:
+		return "checksum"
+	case ProblemLayout:
+		return "layout"
+	case ProblemEOF:
+		return "eof"
+	default:
+		return "unknown"
+	}
+}
+
+// Problem describes a single structural problem found within an archive
+type Problem struct {
+	Class   ProblemClass
+	Path    string // empty when the problem is not specific to a file
+	Offset  int64  // stream offset of the chunk the problem was found at
+	Message string
+}
+
+func (p *Problem) Error() string {
+	if p.Path == "" {
+		return fmt.Sprintf("%s at offset %d: %s", p.Class, p.Offset, p.Message)
+	}
+	return fmt.Sprintf("%s at offset %d: %s: %s", p.Class, p.Offset, p.Path, p.Message)
+}
+
+type payloadRange struct {
+	offset uint64
+	length uint64
+	chunk  int64 // stream offset of the chunk holding the range
+}
+
+type verifyState struct {
+	ranges []payloadRange
+	eof    int64 // stream offset of the EOF chunk, -1 until seen
+}
+
+// Verify reads an archive from r to its end and returns every structural problem found. Each chunk's magic and
+// CRC32 are checked, the payload ranges of each file must be contiguous from offset 0 without overlaps and each
+// file must be terminated by exactly one EOF chunk. Reading stops at the first chunk that can not be parsed.
+// The returned error is only set when the underlying stream fails.
+func Verify(r *Reader) ([]*Problem, error) {
+	var problems []*Problem
+	files := make(map[string]*verifyState)
+	var order []string
+	hash := crc32.NewIEEE()
+
+read:
+	for {
+		chunk, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			switch {
+			case errors.Is(err, ErrTruncated):
+				problems = append(problems, &Problem{Class: ProblemTruncated, Offset: r.start, Message: "stream ends within a chunk"})
+			case errors.Is(err, ErrStreamRead):
+				return problems, err
+			default:
+				problems = append(problems, &Problem{Class: ProblemCorrupt, Offset: r.start, Message: err.Error()})
+			}
+			break
+		}
+
+		if chunk.Type == ChunkTypeUnknown {
+			continue
+		}
+
+		path := string(chunk.Path)
+		state, ok := files[path]
+		if !ok {
+			state = &verifyState{eof: -1}
+			files[path] = state
+			order = append(order, path)
+		}
+
+		if state.eof >= 0 {
+			message := "chunk follows the EOF chunk"
+			if chunk.Type == ChunkTypeEOF {
+				message = "duplicate EOF chunk"
+			}
+			problems = append(problems, &Problem{Class: ProblemEOF, Path: path, Offset: chunk.Offset, Message: message})
+		}
+
+		if chunk.Type == ChunkTypeEOF {
+			if state.eof < 0 {
+				state.eof = chunk.Offset
+				problems = append(problems, checkLayout(path, state.ranges)...)
+			}
+			continue
+		}
+
+		hash.Reset()
+		if _, err = io.Copy(hash, chunk); err != nil {
+			if !errors.Is(err, ErrTruncated) {
+				return problems, err
+			}
+			problems = append(problems, &Problem{Class: ProblemTruncated, Path: path, Offset: chunk.Offset, Message: "stream ends within the payload"})
+			break read
+		}
+		if hash.Sum32() != chunk.Checksum {
+			problems = append(problems, &Problem{
+				Class:   ProblemChecksum,
+				Path:    path,
+				Offset:  chunk.Offset,
+				Message: fmt.Sprintf("checksum %08x does not match payload checksum %08x", chunk.Checksum, hash.Sum32()),
+			})
+		}
+
+		if state.eof < 0 {
+			state.ranges = append(state.ranges, payloadRange{chunk.PayOffset, chunk.PayLen, chunk.Offset})
+		}
+	}
+
+	for _, path := range order {
+		if state := files[path]; state.eof < 0 {
+			problems = append(problems, checkLayout(path, state.ranges)...)
+			problems = append(problems, &Problem{Class: ProblemEOF, Path: path, Offset: r.offset, Message: "missing EOF chunk"})
+		}
+	}
+
+	return problems, nil
+}
+
+// checkLayout reports gaps and overlaps between the payload ranges of a file
+func checkLayout(path string, ranges []payloadRange) []*Problem {
+	var problems []*Problem
+
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].offset < ranges[j].offset })
+
+	var end uint64
+	for _, rng := range ranges {
+		switch {
+		case rng.offset > end:
+			problems = append(problems, &Problem{
+				Class:   ProblemLayout,
+				Path:    path,
+				Offset:  rng.chunk,
+				Message: fmt.Sprintf("gap between offsets %d and %d", end, rng.offset),
+			})
+		case rng.offset < end:
+			problems = append(problems, &Problem{
+				Class:   ProblemLayout,
+				Path:    path,
+				Offset:  rng.chunk,
+				Message: fmt.Sprintf("range at offset %d overlaps previous data ending at %d", rng.offset, end),
+			})
+		}
+		if rng.offset+rng.length > end {
+			end = rng.offset + rng.length
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func verifyBytes(t *testing.T, archive []byte) []ProblemClass {
+	problems, err := Verify(NewReader(bytes.NewReader(archive)))
+	require.NoError(t, err)
+
+	classes := make([]ProblemClass, 0, len(problems))
+	for _, problem := range problems {
+		classes = append(classes, problem.Class)
+	}
+	return classes
+}
+
+func TestVerify(t *testing.T) {
+	assert.Empty(t, verifyBytes(t, xbFile))
+
+	corrupt := append([]byte(nil), xbFile...)
+	corrupt[40] ^= 0xff
+	assert.Equal(t, []ProblemClass{ProblemChecksum}, verifyBytes(t, corrupt))
+
+	assert.Equal(t, []ProblemClass{ProblemTruncated, ProblemEOF}, verifyBytes(t, xbFile[:len(xbFile)-3]))
+	assert.Equal(t, []ProblemClass{ProblemTruncated, ProblemEOF}, verifyBytes(t, xbFile[:42]))
+
+	// file1 EOF chunk repeated after file2
+	duplicate := append(append([]byte(nil), xbFile...), xbFile[44:63]...)
+	assert.Equal(t, []ProblemClass{ProblemEOF}, verifyBytes(t, duplicate))
+
+	// file2 payload starting at offset 3
+	gap := append([]byte(nil), xbFile...)
+	gap[90] = 3
+	assert.Equal(t, []ProblemClass{ProblemLayout}, verifyBytes(t, gap))
+
+	magic := append([]byte(nil), xbFile...)
+	magic[65] = 'X'
+	assert.Equal(t, []ProblemClass{ProblemCorrupt}, verifyBytes(t, magic))
+}
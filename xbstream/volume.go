@@ -0,0 +1,324 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	// volumePath is the path of the ignorable chunks marking the start and end of each volume
+	volumePath = ".xbstream-volume"
+	// volumeChunkType is the type of volume marker chunks, unknown to readers that do not support volumes
+	volumeChunkType = ChunkType('V')
+	// volumePayloadSize is the size of a volume marker payload: set id, volume number and flags
+	volumePayloadSize = 16 + 4 + 4
+	// volumeFinal flags the marker written at the end of the last volume of a set
+	volumeFinal = 0x1
+
+	// VolumeMarkerSize is the encoded size of the chunks that start and end each volume
+	VolumeMarkerSize = 8 + 1 + 1 + 4 + 16 + 8 + 8 + 4 + volumePayloadSize // 16 is len(volumePath)
+	// MinimumVolumeSize is the smallest volume that is guaranteed to hold any chunk written by a File, which
+	// splits its content into chunks of at most MinimumChunkSize bytes
+	MinimumVolumeSize = MinimumChunkSize + MaxPathLength + 34 + 2*VolumeMarkerSize
+)
+
+// ErrVolumeSequence indicates a volume that is missing, out of order or belongs to a different archive
+var ErrVolumeSequence = errors.New("volume sequence error")
+
+// VolumeName returns the name of a volume of the archive base, numbered from 1
+func VolumeName(base string, volume int) string {
+	return fmt.Sprintf("%s.%03d", base, volume)
+}
+
+// IsVolume reports whether header, the first VolumeMarkerSize bytes of a stream, is the marker that starts a volume
+func IsVolume(header []byte) bool {
+	_, err := parseVolumeMarker(header)
+	return err == nil
+}
+
+type volumeMarker struct {
+	set    []byte
+	volume uint32
+	flags  uint32
+}
+
+func encodeVolumeMarker(m *volumeMarker) []byte {
+	payload := make([]byte, volumePayloadSize)
+	copy(payload, m.set)
+	binary.LittleEndian.PutUint32(payload[16:], m.volume)
+	binary.LittleEndian.PutUint32(payload[20:], m.flags)
+
+	chunk := &Chunk{
+		ChunkHeader: ChunkHeader{
+			Flags:    FlagChunkIgnorable,
+			Type:     volumeChunkType,
+			Path:     []byte(volumePath),
+			PayLen:   volumePayloadSize,
+			Checksum: crc32.ChecksumIEEE(payload),
+		},
+	}
+
+	// The marker is a fixed size and its path is always valid, so encoding can not fail
+	header, _ := encodeChunkHeader(chunk)
+	header.Write(payload)
+	return header.Bytes()
+}
+
+func parseVolumeMarker(b []byte) (*volumeMarker, error) {
+	if len(b) != VolumeMarkerSize {
+		return nil, errors.New("short volume marker")
+	}
+
+	chunk, err := NewReader(bytes.NewReader(b)).Next()
+	if err != nil {
+		return nil, err
+	}
+	if chunk.Type != ChunkTypeUnknown || string(chunk.Path) != volumePath || chunk.PayLen != volumePayloadSize {
+		return nil, errors.New("not a volume marker")
+	}
+
+	payload, err := ioutil.ReadAll(chunk)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != chunk.Checksum {
+		return nil, ErrChecksum
+	}
+
+	return &volumeMarker{
+		set:    payload[:16],
+		volume: binary.LittleEndian.Uint32(payload[16:]),
+		flags:  binary.LittleEndian.Uint32(payload[20:]),
+	}, nil
+}
+
+// NewVolumeWriter returns a Writer that splits the archive into volumes of at most size bytes, each opened
+// by open. Volumes only end at chunk boundaries and each starts with an ignorable marker chunk recording its
+// position in the set. Closing the Writer marks the last volume as final so a missing tail can be detected.
+func NewVolumeWriter(open func(volume int) (io.WriteCloser, error), size int64) (*Writer, error) {
+	if size < MinimumVolumeSize {
+		return nil, fmt.Errorf("volume size %d is below the minimum of %d bytes", size, MinimumVolumeSize)
+	}
+
+	set := make([]byte, 16)
+	if _, err := rand.Read(set); err != nil {
+		return nil, err
+	}
+
+	return NewWriter(&volumeWriter{open: open, size: size, set: set}), nil
+}
+
+// volumeWriter writes an archive across volumes, starting a new volume when a chunk would not fit
+type volumeWriter struct {
+	open    func(volume int) (io.WriteCloser, error)
+	size    int64
+	set     []byte
+	volume  int // number of the current volume, zero before the first is opened
+	current io.WriteCloser
+	written int64 // bytes written to the current volume
+}
+
+// reserve ensures the current volume has room for a chunk of n bytes and its final marker
+func (v *volumeWriter) reserve(n int64) error {
+	if n > v.size-2*VolumeMarkerSize {
+		return fmt.Errorf("chunk of %d bytes does not fit in a volume of %d bytes", n, v.size)
+	}
+	if v.current != nil && v.written+n+VolumeMarkerSize <= v.size {
+		return nil
+	}
+	return v.next()
+}
+
+// next closes the current volume and opens the following one
+func (v *volumeWriter) next() error {
+	if v.current != nil {
+		if err := v.current.Close(); err != nil {
+			return err
+		}
+		v.current = nil
+	}
+
+	current, err := v.open(v.volume + 1)
+	if err != nil {
+		return err
+	}
+	v.volume++
+	v.current = current
+	v.written = 0
+
+	_, err = v.Write(encodeVolumeMarker(&volumeMarker{set: v.set, volume: uint32(v.volume)}))
+	return err
+}
+
+func (v *volumeWriter) Write(p []byte) (int, error) {
+	if v.current == nil {
+		if err := v.next(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := v.current.Write(p)
+	v.written += int64(n)
+	return n, err
+}
+
+// Close writes the final marker and closes the last volume. An archive without chunks is a single volume.
+func (v *volumeWriter) Close() error {
+	if v.current == nil {
+		if err := v.next(); err != nil {
+			return err
+		}
+	}
+
+	_, err := v.Write(encodeVolumeMarker(&volumeMarker{set: v.set, volume: uint32(v.volume), flags: volumeFinal}))
+	if cerr := v.current.Close(); err == nil {
+		err = cerr
+	}
+	v.current = nil
+	return err
+}
+
+// NewVolumeReader returns a reader presenting the volumes opened by open as a single archive, with the volume
+// markers removed. Volumes are opened in order from 1 until the final volume. A volume that is missing, out
+// of order or from a different archive is reported as ErrVolumeSequence, open should return an error
+// satisfying os.IsNotExist for a volume that does not exist.
+func NewVolumeReader(open func(volume int) (io.ReadCloser, error)) io.ReadCloser {
+	return &volumeReader{open: open, ended: true, buf: make([]byte, 32*1024)}
+}
+
+type volumeReader struct {
+	open    func(volume int) (io.ReadCloser, error)
+	set     []byte
+	volume  int
+	current io.ReadCloser
+	pending []byte // bytes read from the current volume, the last VolumeMarkerSize may be its final marker
+	ended   bool   // the current volume has been read to its end
+	final   bool   // the current volume is the last of the set
+	buf     []byte
+}
+
+func (v *volumeReader) Read(p []byte) (int, error) {
+	for {
+		// Bytes that can not be part of the final marker are returned immediately
+		n := len(v.pending) - VolumeMarkerSize
+		if v.ended {
+			n = len(v.pending)
+		}
+		if n > 0 {
+			n = copy(p, v.pending[:n])
+			v.pending = v.pending[n:]
+			return n, nil
+		}
+
+		if v.ended {
+			if v.final {
+				return 0, io.EOF
+			}
+			if err := v.next(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		n, err := v.current.Read(v.buf)
+		v.pending = append(v.pending, v.buf[:n]...)
+		if err == io.EOF {
+			v.end()
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// end closes the current volume and strips its final marker, if it has one
+func (v *volumeReader) end() {
+	v.current.Close()
+	v.current = nil
+	v.ended = true
+
+	if len(v.pending) < VolumeMarkerSize {
+		return
+	}
+	tail := v.pending[len(v.pending)-VolumeMarkerSize:]
+	m, err := parseVolumeMarker(tail)
+	if err != nil || !bytes.Equal(m.set, v.set) || int(m.volume) != v.volume || m.flags&volumeFinal == 0 {
+		return
+	}
+	v.pending = v.pending[:len(v.pending)-VolumeMarkerSize]
+	v.final = true
+}
+
+// next opens the following volume and checks its marker
+func (v *volumeReader) next() error {
+	volume := v.volume + 1
+
+	current, err := v.open(volume)
+	if err != nil {
+		if os.IsNotExist(err) && volume > 1 {
+			return fmt.Errorf("%w: volume %d is missing", ErrVolumeSequence, volume)
+		}
+		return err
+	}
+
+	header := make([]byte, VolumeMarkerSize)
+	if _, err = io.ReadFull(current, header); err != nil {
+		current.Close()
+		return fmt.Errorf("%w: volume %d has no volume marker", ErrVolumeSequence, volume)
+	}
+
+	m, err := parseVolumeMarker(header)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("%w: volume %d has no volume marker", ErrVolumeSequence, volume)
+	case v.set != nil && !bytes.Equal(m.set, v.set):
+		err = fmt.Errorf("%w: volume %d belongs to a different archive", ErrVolumeSequence, volume)
+	case int(m.volume) != volume:
+		err = fmt.Errorf("%w: found volume %d where volume %d was expected", ErrVolumeSequence, m.volume, volume)
+	}
+	if err != nil {
+		current.Close()
+		return err
+	}
+
+	v.set = m.set
+	v.volume = volume
+	v.current = current
+	v.ended = false
+
+	return nil
+}
+
+// Close closes the volume being read
+func (v *volumeReader) Close() error {
+	if v.current == nil {
+		return nil
+	}
+	err := v.current.Close()
+	v.current = nil
+	return err
+}
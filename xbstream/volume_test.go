@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeVolumes writes files as chunks of 100 bytes into volumes of at most size bytes
+func writeVolumes(t *testing.T, size int64, files map[string][]byte) [][]byte {
+	var volumes []*bytes.Buffer
+	open := func(volume int) (io.WriteCloser, error) {
+		require.Equal(t, len(volumes)+1, volume)
+		volumes = append(volumes, new(bytes.Buffer))
+		return nopWriteCloser{volumes[volume-1]}, nil
+	}
+
+	var members []testFile
+	for path, content := range files {
+		member := testFile{path: path}
+		for len(content) > 0 {
+			n := 100
+			if n > len(content) {
+				n = len(content)
+			}
+			member.parts = append(member.parts, content[:n])
+			content = content[n:]
+		}
+		members = append(members, member)
+	}
+	writeFiles(t, NewWriter(&volumeWriter{open: open, size: size, set: bytes.Repeat([]byte{byte(size)}, 16)}), members...)
+
+	var result [][]byte
+	for _, volume := range volumes {
+		result = append(result, volume.Bytes())
+	}
+	return result
+}
+
+func readVolumes(volumes [][]byte) io.ReadCloser {
+	return NewVolumeReader(func(volume int) (io.ReadCloser, error) {
+		if volume > len(volumes) || volumes[volume-1] == nil {
+			return nil, os.ErrNotExist
+		}
+		return ioutil.NopCloser(bytes.NewReader(volumes[volume-1])), nil
+	})
+}
+
+func TestVolumes(t *testing.T) {
+	files := map[string][]byte{
+		"a": bytes.Repeat([]byte{1}, 450),
+		"b": bytes.Repeat([]byte{2}, 120),
+	}
+	volumes := writeVolumes(t, 400, files)
+	require.True(t, len(volumes) > 2)
+
+	for _, volume := range volumes {
+		assert.True(t, len(volume) <= 400)
+		assert.True(t, IsVolume(volume[:VolumeMarkerSize]))
+	}
+
+	index, err := BuildIndex(NewReader(readVolumes(volumes)))
+	require.NoError(t, err)
+	require.Len(t, index, 2)
+	for _, entry := range index {
+		assert.True(t, entry.EOF)
+		assert.Equal(t, int64(len(files[entry.Path])), entry.Size)
+	}
+
+	content := new(bytes.Buffer)
+	require.NoError(t, Cat(content, NewReader(readVolumes(volumes)), "a", CatOptions{}))
+	assert.Equal(t, files["a"], content.Bytes())
+
+	readAll := func(volumes [][]byte) error {
+		_, err := ioutil.ReadAll(readVolumes(volumes))
+		return err
+	}
+
+	missing := append([][]byte(nil), volumes...)
+	missing[1] = nil
+	assert.True(t, errors.Is(readAll(missing), ErrVolumeSequence))
+
+	assert.True(t, errors.Is(readAll(volumes[:len(volumes)-1]), ErrVolumeSequence), "missing final volume")
+
+	swapped := append([][]byte(nil), volumes...)
+	swapped[0], swapped[1] = swapped[1], swapped[0]
+	assert.True(t, errors.Is(readAll(swapped), ErrVolumeSequence))
+
+	other := writeVolumes(t, 401, files)
+	mixed := append([][]byte{volumes[0]}, other[1:]...)
+	assert.True(t, errors.Is(readAll(mixed), ErrVolumeSequence))
+}
@@ -21,8 +21,8 @@ package xbstream
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
-	"errors"
 	"hash/crc32"
 	"io"
 	"sync"
@@ -30,35 +30,43 @@ import (
 
 // Writer provides to create and writer files in parallel to an xbstream archive
 type Writer struct {
-	mutex  sync.Mutex
-	writer io.WriteCloser
+	mutex    sync.Mutex
+	writer   io.WriteCloser
+	buffers  sync.Pool // chunk buffers shared by the files of the archive
+	progress progressCounter
+	limiter  *RateLimiter
 }
 
 // File represents a file that is stored within the archive. Exposes an io.WriteCloser interface
 type File struct {
 	path   []byte
 	writer *Writer
-	chunk  []byte
-	pos    int // current chunk slice position
-	free   int // remaining chunk bytes
-	offset int // current file offset
+	chunk  *[]byte // allocated from the writer's pool on the first buffered write
+	pos    int     // current chunk slice position
+	free   int     // remaining chunk bytes
+	offset int     // current file offset
+}
+
+// chunkReserver is implemented by archive writers that must be told the size of each chunk before it is written,
+// such as the writer of a volume set, which starts a new volume rather than split a chunk across two
+type chunkReserver interface {
+	reserve(n int64) error
 }
 
 // NewWriter returns a new archiver Writer
 func NewWriter(writer io.WriteCloser) *Writer {
-	return &Writer{sync.Mutex{}, writer}
+	return &Writer{writer: writer}
 }
 
 // Create a new File within the archive represent by path
 func (w *Writer) Create(path string) (*File, error) {
 	if len(path) > MaxPathLength {
-		return nil, errors.New("max path length exceeded")
+		return nil, ErrPathLength
 	}
 
 	return &File{
 		path:   []byte(path),
 		writer: w,
-		chunk:  make([]byte, MinimumChunkSize),
 		free:   MinimumChunkSize,
 	}, nil
 }
@@ -68,150 +76,177 @@ func (w *Writer) Close() error {
 	return w.writer.Close()
 }
 
-// Writes len(p) to the archive, if len(p) < the remaining buffer size the write will be buffered
-// for a later flush. Otherwise contents within the existing buffer will be flushed
-// and then the contents of p will be written
-func (f *File) Write(p []byte) (int, error) {
-	if len(p) < f.free {
-		n := copy(f.chunk[f.pos:], p)
-		f.pos += n
-		f.free -= n
+// SetProgress registers fn to be called after each chunk is written to the archive. Calls are serialised with
+// the writes of every file, so fn must return quickly. SetProgress must be called before any file is written.
+func (w *Writer) SetProgress(fn func(Progress)) {
+	w.progress.fn = fn
+}
 
-		return len(p), nil
+// SetRateLimit limits the rate at which the archive is written, a nil limiter removes the limit.
+// SetRateLimit must be called before any file is written.
+func (w *Writer) SetRateLimit(l *RateLimiter) {
+	w.limiter = l
+}
+
+// WriteChunk writes a single chunk to the archive, copying PayLen bytes of payload from the chunk's Reader.
+// The header is written as given, so chunks read from another archive keep their offset, flags and checksum.
+// The magic and path length are derived rather than copied.
+func (w *Writer) WriteChunk(chunk *Chunk) error {
+	header, err := encodeChunkHeader(chunk)
+	if err != nil {
+		return err
 	}
 
-	if err := f.Flush(); err != nil {
-		return 0, err
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err = w.limiter.Wait(context.Background(), header.Len()+int(chunk.PayLen)); err != nil {
+		return err
+	}
+
+	if r, ok := w.writer.(chunkReserver); ok {
+		if err = r.reserve(int64(header.Len()) + int64(chunk.PayLen)); err != nil {
+			return err
+		}
+	}
+
+	if _, err = io.Copy(w.writer, header); err != nil {
+		return err
+	}
+
+	if chunk.Type != ChunkTypeEOF && chunk.PayLen > 0 {
+		if _, err = io.CopyN(w.writer, chunk.Reader, int64(chunk.PayLen)); err != nil {
+			return err
+		}
 	}
 
-	return len(p), f.writeChunk(p)
+	w.progress.chunk(chunk)
+
+	return nil
 }
 
-func (f *File) writeChunk(p []byte) error {
+// encodeChunkHeader returns the encoded header of chunk, the payload that follows it is not included
+func encodeChunkHeader(chunk *Chunk) (*bytes.Buffer, error) {
 	var err error
 	buffer := new(bytes.Buffer)
-	chunk := new(ChunkHeader)
+
+	if len(chunk.Path) > MaxPathLength {
+		return nil, ErrPathLength
+	}
 
 	// Chunk Magic
-	chunk.Magic = make([]uint8, len(chunkMagic))
-	copy(chunk.Magic, chunkMagic)
-	if err = binary.Write(buffer, binary.BigEndian, &chunk.Magic); err != nil {
-		return err
+	if err = binary.Write(buffer, binary.BigEndian, chunkMagic); err != nil {
+		return nil, err
 	}
 
 	// Chunk Flags
-	chunk.Flags = 0
 	if err = binary.Write(buffer, binary.LittleEndian, &chunk.Flags); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Chunk Type
-	chunk.Type = ChunkTypePayload
 	if err = binary.Write(buffer, binary.LittleEndian, &chunk.Type); err != nil {
-		return err
+		return nil, err
 	}
 
 	// path Length
-	chunk.PathLen = uint32(len(f.path))
-	if err = binary.Write(buffer, binary.LittleEndian, &chunk.PathLen); err != nil {
-		return err
+	if err = binary.Write(buffer, binary.LittleEndian, uint32(len(chunk.Path))); err != nil {
+		return nil, err
 	}
 
 	// path
-	chunk.Path = f.path
-	if err = binary.Write(buffer, binary.BigEndian, &chunk.Path); err != nil {
-		return err
+	if err = binary.Write(buffer, binary.BigEndian, chunk.Path); err != nil {
+		return nil, err
+	}
+
+	if chunk.Type == ChunkTypeEOF {
+		return buffer, nil
 	}
 
 	// Payload Length
-	chunk.PayLen = uint64(len(p))
 	if err = binary.Write(buffer, binary.LittleEndian, &chunk.PayLen); err != nil {
-		return err
+		return nil, err
 	}
 
-	// Checksum
-	chunk.Checksum = crc32.ChecksumIEEE(p)
-
-	f.writer.mutex.Lock()
-	defer f.writer.mutex.Unlock()
-
 	// Payload Offset
-	chunk.PayOffset = uint64(f.offset)
 	if err = binary.Write(buffer, binary.LittleEndian, &chunk.PayOffset); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Checksum
 	if err = binary.Write(buffer, binary.LittleEndian, &chunk.Checksum); err != nil {
-		return err
-	}
-
-	if _, err = io.Copy(f.writer.writer, buffer); err != nil {
-		return err
-	}
-
-	if _, err = io.Copy(f.writer.writer, bytes.NewReader(p)); err != nil {
-		return err
+		return nil, err
 	}
 
-	f.offset += len(p)
-
-	return nil
+	return buffer, nil
 }
 
-func (f *File) writeEOF() error {
-	var err error
-	buffer := new(bytes.Buffer)
-	chunk := new(Chunk)
-
-	f.writer.mutex.Lock()
-	defer f.writer.mutex.Unlock()
+// Writes len(p) to the archive, if len(p) < the remaining buffer size the write will be buffered
+// for a later flush. Otherwise contents within the existing buffer will be flushed
+// and then the contents of p will be written in chunks of at most MinimumChunkSize bytes
+func (f *File) Write(p []byte) (int, error) {
+	if len(p) < f.free {
+		if f.chunk == nil {
+			f.chunk = f.writer.buffer()
+		}
+		n := copy((*f.chunk)[f.pos:], p)
+		f.pos += n
+		f.free -= n
 
-	// Chunk Magic
-	chunk.Magic = make([]uint8, len(chunkMagic))
-	copy(chunk.Magic, chunkMagic)
-	if err = binary.Write(buffer, binary.BigEndian, &chunk.Magic); err != nil {
-		return err
+		return len(p), nil
 	}
 
-	// Chunk Flags
-	chunk.Flags = 0
-	if err = binary.Write(buffer, binary.LittleEndian, &chunk.Flags); err != nil {
-		return err
+	if err := f.Flush(); err != nil {
+		return 0, err
 	}
 
-	// Chunk Type
-	chunk.Type = ChunkTypeEOF
-	if err = binary.Write(buffer, binary.LittleEndian, &chunk.Type); err != nil {
-		return err
+	written := 0
+	for written < len(p) {
+		n := len(p) - written
+		if n > MinimumChunkSize {
+			n = MinimumChunkSize
+		}
+		if err := f.writeChunk(p[written : written+n]); err != nil {
+			return written, err
+		}
+		written += n
 	}
 
-	// path Length
-	chunk.PathLen = uint32(len(f.path))
-	if err = binary.Write(buffer, binary.LittleEndian, &chunk.PathLen); err != nil {
-		return err
-	}
+	return written, nil
+}
 
-	// path
-	chunk.Path = f.path
-	if err = binary.Write(buffer, binary.BigEndian, &chunk.Path); err != nil {
-		return err
+func (f *File) writeChunk(p []byte) error {
+	chunk := &Chunk{
+		ChunkHeader: ChunkHeader{
+			Type:      ChunkTypePayload,
+			Path:      f.path,
+			PayLen:    uint64(len(p)),
+			PayOffset: uint64(f.offset),
+			Checksum:  crc32.ChecksumIEEE(p),
+		},
+		Reader: bytes.NewReader(p),
 	}
 
-	if _, err = io.Copy(f.writer.writer, buffer); err != nil {
+	if err := f.writer.WriteChunk(chunk); err != nil {
 		return err
 	}
 
+	f.offset += len(p)
+
 	return nil
 }
 
+func (f *File) writeEOF() error {
+	return f.writer.WriteChunk(&Chunk{ChunkHeader: ChunkHeader{Type: ChunkTypeEOF, Path: f.path}})
+}
+
 // Flush the current contents in the buffer into the archive
 func (f *File) Flush() error {
 	if f.pos == 0 {
 		return nil
 	}
 
-	if err := f.writeChunk(f.chunk[:f.pos]); err != nil {
+	if err := f.writeChunk((*f.chunk)[:f.pos]); err != nil {
 		return err
 	}
 
@@ -227,5 +262,32 @@ func (f *File) Close() error {
 		return err
 	}
 
+	if f.chunk != nil {
+		f.writer.buffers.Put(f.chunk)
+		f.chunk = nil
+	}
+
 	return f.writeEOF()
 }
+
+// Abort flushes any buffered content and releases the file without writing its EOF chunk, so readers of the
+// archive see the file as incomplete. It is used when the content of a file could not be read in full.
+func (f *File) Abort() error {
+	err := f.Flush()
+
+	if f.chunk != nil {
+		f.writer.buffers.Put(f.chunk)
+		f.chunk = nil
+	}
+
+	return err
+}
+
+// buffer returns a chunk buffer from the pool, allocating one if the pool is empty
+func (w *Writer) buffer() *[]byte {
+	if b, ok := w.buffers.Get().(*[]byte); ok {
+		return b
+	}
+	b := make([]byte, MinimumChunkSize)
+	return &b
+}
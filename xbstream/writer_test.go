@@ -0,0 +1,155 @@
+/*
+ * Copyright (C) 2017 Sean McGrail
+ * Copyright (C) 2011-2017 Percona LLC and/or its affiliates.
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License
+ * as published by the Free Software Foundation; either version 2
+ * of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+ */
+
+package xbstream
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// testFile is a member of a test archive, each of its parts is written as a chunk of its own
+type testFile struct {
+	path  string
+	parts [][]byte
+}
+
+// buildArchive writes files in order to a new archive and returns the encoded archive
+func buildArchive(t *testing.T, files ...testFile) []byte {
+	archive := nopWriteCloser{new(bytes.Buffer)}
+	writeFiles(t, NewWriter(archive), files...)
+	return archive.Bytes()
+}
+
+// writeFiles writes files in order to w and closes it
+func writeFiles(t *testing.T, w *Writer, files ...testFile) {
+	for _, file := range files {
+		f, err := w.Create(file.path)
+		require.NoError(t, err)
+		for _, part := range file.parts {
+			_, err = f.Write(part)
+			require.NoError(t, err)
+			require.NoError(t, f.Flush())
+		}
+		require.NoError(t, f.Close())
+	}
+	require.NoError(t, w.Close())
+}
+
+// splitChunks returns the encoded bytes of every chunk in archive
+func splitChunks(t *testing.T, archive []byte) [][]byte {
+	var offsets []int64
+	r := NewReader(bytes.NewReader(archive))
+	for {
+		chunk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		offsets = append(offsets, chunk.Offset)
+	}
+	offsets = append(offsets, int64(len(archive)))
+
+	var chunks [][]byte
+	for i := 0; i < len(offsets)-1; i++ {
+		chunks = append(chunks, archive[offsets[i]:offsets[i+1]])
+	}
+	return chunks
+}
+
+func TestWriterReusesChunkBuffers(t *testing.T) {
+	archive := nopWriteCloser{new(bytes.Buffer)}
+	w := NewWriter(archive)
+
+	first, err := w.Create("first")
+	require.NoError(t, err)
+	_, err = first.Write([]byte("buffered"))
+	require.NoError(t, err)
+	require.NoError(t, first.Close())
+	assert.Nil(t, first.chunk, "closed files release their chunk buffer")
+
+	second, err := w.Create("second")
+	require.NoError(t, err)
+	assert.Nil(t, second.chunk, "chunk buffers are allocated on the first buffered write")
+	_, err = second.Write([]byte("reused"))
+	require.NoError(t, err)
+	require.NoError(t, second.Close())
+
+	r := NewReader(bytes.NewReader(archive.Bytes()))
+	for _, expected := range []string{"buffered", "", "reused", ""} {
+		chunk, err := r.Next()
+		require.NoError(t, err)
+		payload, err := ioutil.ReadAll(chunk)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(payload))
+	}
+}
+
+func TestFileAbort(t *testing.T) {
+	archive := nopWriteCloser{new(bytes.Buffer)}
+	w := NewWriter(archive)
+
+	f, err := w.Create("partial")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("read before failing"))
+	require.NoError(t, err)
+	require.NoError(t, f.Abort())
+
+	problems, err := Verify(NewReader(bytes.NewReader(archive.Bytes())))
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	assert.Equal(t, ProblemEOF, problems[0].Class)
+}
+
+func TestFileWriteSplitsLargeWrites(t *testing.T) {
+	archive := nopWriteCloser{new(bytes.Buffer)}
+	w := NewWriter(archive)
+
+	f, err := w.Create("large")
+	require.NoError(t, err)
+	n, err := f.Write(make([]byte, 2*MinimumChunkSize+1))
+	require.NoError(t, err)
+	assert.Equal(t, 2*MinimumChunkSize+1, n)
+	require.NoError(t, f.Close())
+
+	r := NewReader(bytes.NewReader(archive.Bytes()))
+	var sizes []uint64
+	for {
+		chunk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if chunk.Type == ChunkTypePayload {
+			sizes = append(sizes, chunk.PayLen)
+		}
+	}
+	assert.Equal(t, []uint64{MinimumChunkSize, MinimumChunkSize, 1}, sizes)
+}